@@ -7,6 +7,8 @@ import (
 	"bytes"
 	"embed"
 	"os"
+	"path/filepath"
+	"strings"
 	"text/template"
 
 	"github.com/spf13/cobra"
@@ -50,7 +52,7 @@ Read the template script 'tome-wrapper.sh.tmpl' for more information on how the
 		config := NewConfig()
 		s := ScriptTemplate{
 			ExecutableAlias: config.ExecutableName(),
-			Root:            config.RootDir(),
+			Root:            strings.Join(config.RootDirs(), string(filepath.ListSeparator)),
 		}
 		t, err := template.ParseFS(content, "embeds/tome-wrapper.sh.tmpl")
 		// Capture any error
@@ -0,0 +1,275 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AuditConfigFile configures auditing of the scripts of its root, e.g.
+//
+//	mandatory: true
+//	sinks:
+//	  - type: file
+//	    path: /var/log/tome-cli/audit.jsonl
+//	  - type: syslog
+//	  - type: http
+//	    url: http://127.0.0.1:9880/audit
+//	redact:
+//	  flags: [--password, --token]
+//	  patterns: ['^AKIA[0-9A-Z]{16}$']
+const AuditConfigFile = ".tome-audit.yaml"
+
+// redactedArg replaces arguments hidden by redaction rules
+const redactedArg = "[REDACTED]"
+
+// AuditConfig is the parsed form of a root's AuditConfigFile
+type AuditConfig struct {
+	Mandatory bool              `yaml:"mandatory"` // refuse to run scripts when an event can't be written
+	Sinks     []AuditSinkConfig `yaml:"sinks"`
+	Redact    AuditRedaction    `yaml:"redact"`
+}
+
+// AuditRedaction hides secrets from the recorded arguments
+type AuditRedaction struct {
+	Flags    []string `yaml:"flags"`    // the values of these flags, given as --flag value or --flag=value
+	Patterns []string `yaml:"patterns"` // whole arguments matching these regular expressions
+}
+
+// AuditHook is a hook which ran for an audited script
+type AuditHook struct {
+	Phase string `json:"phase"`
+	Path  string `json:"path"`
+}
+
+// AuditEvent is written before an audited script's hooks run and once the script finished
+type AuditEvent struct {
+	ID           string      `json:"id"` // shared by the start and finish events of a run
+	Event        string      `json:"event"`
+	Time         time.Time   `json:"time"`
+	User         string      `json:"user"`
+	Host         string      `json:"host"`
+	Executable   string      `json:"executable"`
+	Command      []string    `json:"command"`
+	Script       string      `json:"script"`
+	ScriptSHA256 string      `json:"script_sha256"`
+	Root         string      `json:"root"`
+	Cwd          string      `json:"cwd"`
+	Args         []string    `json:"args"`
+	Hooks        []AuditHook `json:"hooks,omitempty"` // the hooks which ran, in the finish event
+	ExitCode     *int        `json:"exit_code,omitempty"`
+	Signal       string      `json:"signal,omitempty"`
+	DurationMs   int64       `json:"duration_ms,omitempty"`
+}
+
+// Auditor writes the audit events of a single run to the sinks configured for its root.
+// A nil Auditor audits nothing, for roots without an AuditConfigFile.
+type Auditor struct {
+	config   *AuditConfig
+	sinks    []AuditSink
+	patterns []*regexp.Regexp
+	start    AuditEvent
+}
+
+// LoadAuditConfig reads the audit config of root, nil when the root has none
+func LoadAuditConfig(root string) (*AuditConfig, error) {
+	path := filepath.Join(root, AuditConfigFile)
+	body, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config := &AuditConfig{}
+	if err := yaml.Unmarshal(body, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(config.Sinks) == 0 {
+		return nil, fmt.Errorf("%s: no sinks configured", path)
+	}
+	return config, nil
+}
+
+// NewAuditor prepares auditing the runs of script.
+// An unreadable or invalid audit config is an error, as it may have been mandatory.
+func NewAuditor(script *Script) (*Auditor, error) {
+	config, err := LoadAuditConfig(script.root)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	auditor := &Auditor{config: config}
+	for _, pattern := range config.Redact.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid redaction pattern: %w", AuditConfigFile, err)
+		}
+		auditor.patterns = append(auditor.patterns, compiled)
+	}
+	for _, sinkConfig := range config.Sinks {
+		sink, err := NewAuditSink(sinkConfig, script.root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", AuditConfigFile, err)
+		}
+		auditor.sinks = append(auditor.sinks, sink)
+	}
+	return auditor, nil
+}
+
+// Start records that script is about to run with args, before any of its hooks.
+// The error is only returned when auditing is mandatory, otherwise it is printed as a warning.
+func (a *Auditor) Start(script *Script, args []string) error {
+	if a == nil {
+		return nil
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	a.start = AuditEvent{
+		ID:         hex.EncodeToString(id),
+		Event:      "start",
+		Time:       time.Now(),
+		User:       auditUser(),
+		Executable: NewConfig().ExecutableName(),
+		Command:    script.PathSegments(),
+		Script:     script.path,
+		Root:       script.root,
+		Args:       a.Redact(args),
+	}
+	if a.start.Executable == "" {
+		a.start.Executable = executableName
+	}
+	a.start.Host, _ = os.Hostname()
+	a.start.Cwd, _ = os.Getwd()
+	hash, err := fileSHA256(script.path)
+	if err != nil {
+		return a.failed(fmt.Errorf("unable to hash %s: %w", script.path, err))
+	}
+	a.start.ScriptSHA256 = hash
+	return a.failed(a.write(a.start))
+}
+
+// Finish records how the run ended and the hooks which ran, keyed by phase.
+// A run stopped by a failing pre-run hook finishes without the script having run.
+func (a *Auditor) Finish(result *ExecResult, hooks map[string][]Hook) error {
+	if a == nil {
+		return nil
+	}
+	event := a.start
+	event.Event = "finish"
+	event.Time = time.Now()
+	exitCode := result.ExitCode
+	event.ExitCode = &exitCode
+	event.DurationMs = result.Duration.Milliseconds()
+	if result.Signal != 0 {
+		event.Signal = result.Signal.String()
+	}
+	event.Hooks = []AuditHook{}
+	for _, phase := range []string{HookPhasePre, HookPhaseOnFailure, HookPhasePost} {
+		event.Hooks = append(event.Hooks, auditHooks(phase, hooks[phase])...)
+	}
+	return a.failed(a.write(event))
+}
+
+// write sends event to every sink, a failing sink does not keep the others from receiving it
+func (a *Auditor) write(event AuditEvent) error {
+	var errs []error
+	for _, sink := range a.sinks {
+		if err := sink.Write(&event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// failed returns err when auditing is mandatory and warns about it otherwise
+func (a *Auditor) failed(err error) error {
+	if err == nil {
+		return nil
+	}
+	if a.config.Mandatory {
+		return fmt.Errorf("audit failed: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: audit failed: %v\n", err)
+	return nil
+}
+
+// Redact returns args with the values of redacted flags and arguments matching redacted patterns hidden
+func (a *Auditor) Redact(args []string) []string {
+	redacted := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		name, _, assigned := strings.Cut(arg, "=")
+		switch {
+		case redactNext:
+			redacted[i] = redactedArg
+			redactNext = false
+		case a.redactsFlag(arg):
+			redacted[i] = arg
+			redactNext = true
+		case assigned && a.redactsFlag(name):
+			redacted[i] = name + "=" + redactedArg
+		case a.matchesPattern(arg):
+			redacted[i] = redactedArg
+		default:
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
+
+func (a *Auditor) redactsFlag(arg string) bool {
+	return slices.Contains(a.config.Redact.Flags, arg)
+}
+
+func (a *Auditor) matchesPattern(arg string) bool {
+	for _, pattern := range a.patterns {
+		if pattern.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+func auditHooks(phase string, hooks []Hook) []AuditHook {
+	audited := []AuditHook{}
+	for _, hook := range hooks {
+		audited = append(audited, AuditHook{Phase: phase, Path: hook.Path})
+	}
+	return audited
+}
+
+// auditUser returns the name of the user running tome-cli
+func auditUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
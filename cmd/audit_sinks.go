@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditSinkConfig is one destination of audit events in an AuditConfigFile
+type AuditSinkConfig struct {
+	Type    string        `yaml:"type"`    // file, syslog or http
+	Path    string        `yaml:"path"`    // file: JSON lines are appended, relative to the root
+	Tag     string        `yaml:"tag"`     // syslog: defaults to tome-cli
+	URL     string        `yaml:"url"`     // http: events are POSTed as JSON
+	Timeout time.Duration `yaml:"timeout"` // http: defaults to 5s
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	Write(event *AuditEvent) error
+}
+
+// auditSinkTypes builds the sink of each type, keyed by the type in AuditSinkConfig
+var auditSinkTypes = map[string]func(config AuditSinkConfig, root string) (AuditSink, error){
+	"file":   newFileAuditSink,
+	"syslog": newSyslogAuditSink,
+	"http":   newHTTPAuditSink,
+}
+
+// NewAuditSink builds the sink described by config for the scripts of root
+func NewAuditSink(config AuditSinkConfig, root string) (AuditSink, error) {
+	build, ok := auditSinkTypes[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown audit sink type %q", config.Type)
+	}
+	return build(config, root)
+}
+
+// fileAuditSink appends events as JSON lines
+type fileAuditSink struct {
+	path string
+}
+
+func newFileAuditSink(config AuditSinkConfig, root string) (AuditSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file audit sink needs a path")
+	}
+	path := config.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	return &fileAuditSink{path: path}, nil
+}
+
+// Write appends event in a single write, so concurrent runs never interleave
+func (s *fileAuditSink) Write(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// syslogAuditSink logs events as JSON to the local syslog daemon
+type syslogAuditSink struct {
+	tag string
+}
+
+func newSyslogAuditSink(config AuditSinkConfig, root string) (AuditSink, error) {
+	tag := config.Tag
+	if tag == "" {
+		tag = "tome-cli"
+	}
+	return &syslogAuditSink{tag: tag}, nil
+}
+
+func (s *syslogAuditSink) Write(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	writer, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, s.tag)
+	if err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	defer writer.Close()
+	return writer.Notice(string(line))
+}
+
+// httpAuditSink POSTs events to an endpoint such as a local log shipper
+type httpAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPAuditSink(config AuditSinkConfig, root string) (AuditSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("http audit sink needs a url")
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return &httpAuditSink{url: config.URL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *httpAuditSink) Write(event *AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestAuditor tests writing audit events of a run to the sinks of its root
+func TestAuditor(t *testing.T) {
+	t.Run("writes start and finish events to every sink", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		var posted []AuditEvent
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event AuditEvent
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Error(err)
+			}
+			posted = append(posted, event)
+		}))
+		defer server.Close()
+		writeTestScript(t, filepath.Join(roots[0], AuditConfigFile), "sinks:\n  - type: file\n    path: audit.jsonl\n  - type: http\n    url: "+server.URL+"\n")
+		writeTestScript(t, filepath.Join(roots[0], "db", "restore"), "#!/bin/sh\n")
+		script := NewScript(filepath.Join(roots[0], "db", "restore"), roots[0])
+		pre := []Hook{{Path: filepath.Join(roots[0], ".hooks.d", "00-check"), Name: "00-check"}}
+
+		auditor, err := NewAuditor(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auditor.Start(script, []string{"prod"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := auditor.Finish(&ExecResult{ExitCode: 3}, map[string][]Hook{HookPhasePre: pre, HookPhasePost: {{Path: "/post"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(filepath.Join(roots[0], "audit.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var written []AuditEvent
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var event AuditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatal(err)
+			}
+			written = append(written, event)
+		}
+		if len(written) != 2 || len(posted) != 2 {
+			t.Fatalf("expected 2 events in each sink, got %d and %d", len(written), len(posted))
+		}
+
+		start, finish := written[0], written[1]
+		if start.Event != "start" || start.ScriptSHA256 == "" || start.User == "" || len(start.Hooks) != 0 || !reflect.DeepEqual(start.Command, []string{"db", "restore"}) {
+			t.Errorf("expected a start event describing the script, got %+v", start)
+		}
+		if finish.Event != "finish" || finish.ID != start.ID || finish.ExitCode == nil || *finish.ExitCode != 3 {
+			t.Errorf("expected a finish event with the exit code, got %+v", finish)
+		}
+		if len(finish.Hooks) != 2 || finish.Hooks[0].Phase != HookPhasePre || finish.Hooks[1].Phase != HookPhasePost {
+			t.Errorf("expected pre and post hooks, got %+v", finish.Hooks)
+		}
+		if posted[0].ID != start.ID {
+			t.Errorf("expected the same events to be posted, got %+v", posted[0])
+		}
+	})
+
+	t.Run("redacts flag values and patterns", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], AuditConfigFile), "sinks: [{type: file, path: audit.jsonl}]\nredact:\n  flags: [--password]\n  patterns: ['^AKIA']\n")
+		auditor, err := NewAuditor(NewScript(filepath.Join(roots[0], "deploy"), roots[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := auditor.Redact([]string{"prod", "--password", "hunter2", "--password=hunter2", "AKIAXYZ", "--user", "bob"})
+		expected := []string{"prod", "--password", redactedArg, "--password=" + redactedArg, redactedArg, "--user", "bob"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("mandatory auditing fails closed", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/sh\n")
+		script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+		unwritable := "sinks: [{type: file, path: missing/audit.jsonl}]\n"
+
+		writeTestScript(t, filepath.Join(roots[0], AuditConfigFile), unwritable)
+		auditor, err := NewAuditor(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auditor.Start(script, nil); err != nil {
+			t.Errorf("expected optional auditing to only warn, got %v", err)
+		}
+
+		writeTestScript(t, filepath.Join(roots[0], AuditConfigFile), "mandatory: true\n"+unwritable)
+		auditor, err = NewAuditor(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auditor.Start(script, nil); err == nil || !strings.Contains(err.Error(), "audit failed") {
+			t.Errorf("expected mandatory auditing to fail, got %v", err)
+		}
+	})
+
+	t.Run("invalid config is an error", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+		for _, config := range []string{"sinks: [{type: carrier-pigeon}]\n", "mandatory: true\n", "sinks: [{type: syslog}]\nredact: {patterns: ['(']}\n"} {
+			writeTestScript(t, filepath.Join(roots[0], AuditConfigFile), config)
+			if _, err := NewAuditor(script); err == nil {
+				t.Errorf("expected %q to be rejected", config)
+			}
+		}
+	})
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// CompleteKey declares a completion which tome-cli resolves without running the script
+//
+//	# TOME_COMPLETE 1: values staging production
+//	# TOME_COMPLETE 2: files *.yaml *.yml
+//	# TOME_COMPLETE --out: dirs
+//	# TOME_COMPLETE --profile: script aws profiles
+//	# TOME_COMPLETE *: dynamic
+//
+// The target is a 1-based positional index, * for any positional, or a declared flag.
+// dynamic falls back to running the script with --completion.
+const CompleteKey = "TOME_COMPLETE"
+
+const (
+	CompleteValues  = "values"
+	CompleteFiles   = "files"
+	CompleteDirs    = "dirs"
+	CompleteScript  = "script"
+	CompleteDynamic = "dynamic"
+)
+
+// CompletionSpec is a single TOME_COMPLETE declaration
+type CompletionSpec struct {
+	Target string
+	Kind   string
+	Args   []string
+}
+
+// ParseCompletionSpecs returns the TOME_COMPLETE declarations of the help text
+func ParseCompletionSpecs(help string) []CompletionSpec {
+	var specs []CompletionSpec
+	for _, line := range strings.Split(help, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, CompleteKey+" ") {
+			continue
+		}
+		target, source, found := strings.Cut(strings.TrimPrefix(line, CompleteKey+" "), ":")
+		fields := strings.Fields(source)
+		if !found || len(fields) == 0 {
+			log.Debugw("ignoring malformed completion declaration", "line", line)
+			continue
+		}
+		specs = append(specs, CompletionSpec{
+			Target: strings.TrimSpace(target),
+			Kind:   fields[0],
+			Args:   fields[1:],
+		})
+	}
+	return specs
+}
+
+// CompletionSpecs returns the completions declared in the script header
+func (s *Script) CompletionSpecs() []CompletionSpec {
+	return ParseCompletionSpecs(s.help)
+}
+
+// completionTarget names what is being completed after scriptArgs:
+// the flag awaiting a value, or the 1-based index of the next positional
+func completionTarget(options []OptionSpec, scriptArgs []string) (target string, flagValue bool) {
+	if expectsOptionValue(options, scriptArgs) {
+		return scriptArgs[len(scriptArgs)-1], true
+	}
+
+	position := 1
+	for i := 0; i < len(scriptArgs); i++ {
+		arg := scriptArgs[i]
+		if arg == "--" {
+			position += len(scriptArgs) - i - 1
+			break
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			if spec := findOption(options, arg); spec != nil && spec.Value != "" {
+				// Skip the flag's value
+				i++
+			}
+			continue
+		}
+		position++
+	}
+	return strconv.Itoa(position), false
+}
+
+// findCompletionSpec returns the declaration for target. Flags match any of the
+// option's names, positionals fall back to a * declaration.
+func findCompletionSpec(specs []CompletionSpec, options []OptionSpec, target string, flagValue bool) *CompletionSpec {
+	names := []string{target}
+	if flagValue {
+		if option := findOption(options, target); option != nil {
+			names = option.Names
+		}
+	}
+	for i := range specs {
+		if containsString(names, specs[i].Target) {
+			return &specs[i]
+		}
+	}
+	if flagValue {
+		return nil
+	}
+	for i := range specs {
+		if specs[i].Target == "*" {
+			return &specs[i]
+		}
+	}
+	return nil
+}
+
+// Complete resolves a static completion declaration
+func (r *ScriptRoots) Complete(spec CompletionSpec, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch spec.Kind {
+	case CompleteValues:
+		return filterPrefix(spec.Args, toComplete), cobra.ShellCompDirectiveNoFileComp
+	case CompleteDirs:
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	case CompleteFiles:
+		return completeFiles(spec.Args, toComplete)
+	case CompleteScript:
+		return r.completeFromScript(spec.Args, toComplete)
+	}
+	log.Debugw("unknown completion kind", "kind", spec.Kind, "target", spec.Target)
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFiles leaves *.ext globs to the shell, other globs are matched
+// here relative to the directory being completed
+func completeFiles(patterns []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(patterns) == 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+
+	var extensions []string
+	for _, pattern := range patterns {
+		ext := strings.TrimPrefix(pattern, "*.")
+		if ext == pattern || strings.ContainsAny(ext, "*?[/") {
+			extensions = nil
+			break
+		}
+		extensions = append(extensions, ext)
+	}
+	if len(extensions) > 0 {
+		return extensions, cobra.ShellCompDirectiveFilterFileExt
+	}
+
+	dir := filepath.Dir(toComplete)
+	var matches []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			log.Debugw("invalid completion glob", "pattern", pattern, "error", err)
+			continue
+		}
+		for _, match := range found {
+			if dir == "." && !strings.HasPrefix(toComplete, "./") {
+				match = strings.TrimPrefix(match, "./")
+			}
+			matches = append(matches, match)
+		}
+	}
+	return filterPrefix(matches, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFromScript runs another script from the roots and completes its output lines,
+// bounded by the completion timeout like --completion
+func (r *ScriptRoots) completeFromScript(args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	s, rest := r.Resolve(args)
+	if s == nil {
+		log.Debugw("completion script not found", "args", args)
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.CompletionTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.path, rest...)
+	// Children holding stdout open must not outlive the deadline
+	cmd.WaitDelay = 100 * time.Millisecond
+	cmd.Env = append(os.Environ(), "TOME_ROOT="+s.root)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		log.Debugw("completion script failed", "path", s.path, "error", err, "timeout", ctx.Err() == context.DeadlineExceeded)
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var values []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return filterPrefix(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var filtered []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
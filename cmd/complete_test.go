@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// TestParseCompletionSpecs tests reading TOME_COMPLETE declarations
+func TestParseCompletionSpecs(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+	help := "USAGE: $0 <env>\nTOME_COMPLETE 1: values staging production\nTOME_COMPLETE --out: dirs\nTOME_COMPLETE 2:\nTOME_COMPLETION"
+	expected := []CompletionSpec{
+		{Target: "1", Kind: CompleteValues, Args: []string{"staging", "production"}},
+		{Target: "--out", Kind: CompleteDirs, Args: []string{}},
+	}
+	if got := ParseCompletionSpecs(help); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+// TestCompletionTarget tests locating the argument being completed
+func TestCompletionTarget(t *testing.T) {
+	options := []OptionSpec{
+		{Names: []string{"--profile", "-p"}, Value: "name"},
+		{Names: []string{"--force"}},
+	}
+
+	tests := []struct {
+		args      []string
+		target    string
+		flagValue bool
+	}{
+		{nil, "1", false},
+		{[]string{"staging"}, "2", false},
+		{[]string{"--profile", "dev", "staging"}, "2", false},
+		{[]string{"--force", "staging"}, "2", false},
+		{[]string{"staging", "-p"}, "-p", true},
+		{[]string{"--", "-x"}, "2", false},
+	}
+	for _, tt := range tests {
+		target, flagValue := completionTarget(options, tt.args)
+		if target != tt.target || flagValue != tt.flagValue {
+			t.Errorf("args %v: expected (%s, %t), got (%s, %t)", tt.args, tt.target, tt.flagValue, target, flagValue)
+		}
+	}
+
+	specs := []CompletionSpec{
+		{Target: "--profile", Kind: CompleteValues},
+		{Target: "*", Kind: CompleteDirs},
+	}
+	if spec := findCompletionSpec(specs, options, "-p", true); spec == nil || spec.Target != "--profile" {
+		t.Errorf("expected -p to match the --profile declaration, got %+v", spec)
+	}
+	if spec := findCompletionSpec(specs, options, "3", false); spec == nil || spec.Kind != CompleteDirs {
+		t.Errorf("expected * declaration for positional 3, got %+v", spec)
+	}
+}
+
+// TestDeclarativeCompletions tests completion without executing the script
+func TestDeclarativeCompletions(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	// The script fails loudly if it is ever run with --completion
+	writeTestScript(t, filepath.Join(roots[0], "deploy"), `#!/bin/bash
+# USAGE: $0 <env> <config> [--profile <name>]
+# OPTION: --profile <name> - AWS profile
+# OPTION: --out <dir> - Output directory
+# TOME_COMPLETE 1: values staging production
+# TOME_COMPLETE 2: files *.yaml
+# TOME_COMPLETE --profile: script aws profiles
+# TOME_COMPLETE --out: dirs
+# TOME_COMPLETE 3: dynamic
+# TOME_COMPLETION
+echo "third"
+`)
+	writeTestScript(t, filepath.Join(roots[0], "aws", "profiles"), "#!/bin/bash\necho dev\necho prod\n")
+
+	tests := []struct {
+		name        string
+		args        []string
+		toComplete  string
+		completions []string
+		directive   cobra.ShellCompDirective
+	}{
+		{"fixed values", []string{"deploy"}, "pro", []string{"production"}, cobra.ShellCompDirectiveNoFileComp},
+		{"file extensions", []string{"deploy", "staging"}, "", []string{"yaml"}, cobra.ShellCompDirectiveFilterFileExt},
+		{"values from script", []string{"deploy", "--profile"}, "", []string{"dev", "prod"}, cobra.ShellCompDirectiveNoFileComp},
+		{"declared dynamic", []string{"deploy", "staging", "app.yaml"}, "", []string{"third"}, cobra.ShellCompDirectiveNoFileComp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completions, directive := ValidArgsFunctionForScripts(nil, tt.args, tt.toComplete)
+			if !reflect.DeepEqual(completions, tt.completions) {
+				t.Errorf("expected %v, got %v", tt.completions, completions)
+			}
+			if directive != tt.directive {
+				t.Errorf("expected directive %d, got %d", tt.directive, directive)
+			}
+		})
+	}
+
+	t.Run("flag targets through the root command", func(t *testing.T) {
+		chdirTest(t, t.TempDir())
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		cases := []struct {
+			args     []string
+			expected string
+		}{
+			{[]string{"__complete", "deploy", "--profile", ""}, "dev\nprod\n:4\n"},
+			{[]string{"__complete", "exec", "deploy", "staging", "--out", ""}, ":16\n"},
+		}
+		for _, tc := range cases {
+			if got := executeRootCmd(t, tc.args...); got != tc.expected {
+				t.Errorf("%v: expected %q, got %q", tc.args, tc.expected, got)
+			}
+		}
+	})
+}
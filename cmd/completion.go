@@ -59,7 +59,11 @@ PowerShell:
 Using completions from within child scripts
 
 Once completions discover an executable and non-ignored script,
-tome-cli will check if the script has TOME_COMPLETION declared in file.
+tome-cli first checks for TOME_COMPLETE declarations in the script header,
+such as "# TOME_COMPLETE 1: values staging production", and resolves those
+itself without running the script.
+
+Otherwise tome-cli will check if the script has TOME_COMPLETION declared in file.
 If so, it will attempt fetch completions from the script itself.
 
 This is accomplished by passing --complete to the script and capturing the output.
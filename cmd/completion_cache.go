@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultCompletionTimeout bounds a script's --completion invocation
+// so a hung script cannot freeze the user's shell
+const defaultCompletionTimeout = 5 * time.Second
+
+// completionCacheTTL matches the header marker opting a script into
+// caching its completion results, e.g.
+//
+//	# TOME_COMPLETION_CACHE=5m
+var completionCacheTTL = regexp.MustCompile(`TOME_COMPLETION_CACHE=(\S+)`)
+
+// CompletionTimeout returns how long a script may take to produce completions.
+// It is read from completion_timeout, e.g. TOME_COMPLETION_TIMEOUT=2s.
+func (c *Config) CompletionTimeout() time.Duration {
+	raw := c.EnvVarOrViperValue("completion_timeout")
+	if raw == "" {
+		return defaultCompletionTimeout
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		log.Debugw("invalid completion timeout, using default", "value", raw, "error", err)
+		return defaultCompletionTimeout
+	}
+	return timeout
+}
+
+// CompletionCacheTTL returns how long the script's completions may be reused,
+// zero when the script did not opt into caching
+func (s *Script) CompletionCacheTTL() time.Duration {
+	match := completionCacheTTL.FindStringSubmatch(s.help)
+	if match == nil {
+		return 0
+	}
+	ttl, err := time.ParseDuration(match[1])
+	if err != nil {
+		log.Debugw("invalid completion cache ttl", "path", s.path, "value", match[1], "error", err)
+		return 0
+	}
+	return ttl
+}
+
+// CompletionCacheEntry is a cached completion result
+type CompletionCacheEntry struct {
+	ExpiresAt   time.Time                `json:"expires_at"`
+	Completions []string                 `json:"completions"`
+	Directive   cobra.ShellCompDirective `json:"directive"`
+}
+
+// CompletionCache stores completion results of one script invocation
+// under the user cache directory
+type CompletionCache struct {
+	path string
+	ttl  time.Duration
+}
+
+// NewCompletionCache returns the cache for the script completing args and toComplete.
+// The key includes the script's modification time and size so edits invalidate it.
+func NewCompletionCache(s *Script, args []string, toComplete string) *CompletionCache {
+	ttl := s.CompletionCacheTTL()
+	if ttl <= 0 {
+		return nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil
+	}
+	cacheDir, err := tomeCacheDir()
+	if err != nil {
+		log.Debugw("unable to determine cache dir", "error", err)
+		return nil
+	}
+
+	key, err := json.Marshal([]interface{}{s.path, info.ModTime().UnixNano(), info.Size(), args, toComplete})
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(key)
+	return &CompletionCache{
+		path: filepath.Join(cacheDir, "completions", hex.EncodeToString(sum[:])+".json"),
+		ttl:  ttl,
+	}
+}
+
+// Get returns the cached completions when present and not expired
+func (c *CompletionCache) Get() ([]string, cobra.ShellCompDirective, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	body, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, 0, false
+	}
+	entry := CompletionCacheEntry{}
+	if err := json.Unmarshal(body, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		return nil, 0, false
+	}
+	return entry.Completions, entry.Directive, true
+}
+
+// Put stores completions, logging instead of failing because the cache is only an optimization
+func (c *CompletionCache) Put(completions []string, directive cobra.ShellCompDirective) {
+	if c == nil || directive&cobra.ShellCompDirectiveError != 0 {
+		return
+	}
+	body, err := json.Marshal(CompletionCacheEntry{
+		ExpiresAt:   time.Now().Add(c.ttl),
+		Completions: completions,
+		Directive:   directive,
+	})
+	if err == nil {
+		err = writeFileAtomic(c.path, body)
+	}
+	if err != nil {
+		log.Debugw("unable to write completion cache", "path", c.path, "error", err)
+	}
+	pruneExpiredEntries(filepath.Dir(c.path))
+}
+
+// pruneExpiredEntries removes the expired or unreadable entries of a cache directory,
+// every distinct key writes a file so the directory would otherwise keep growing
+func pruneExpiredEntries(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		body, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		entry := struct {
+			ExpiresAt time.Time `json:"expires_at"`
+		}{}
+		if json.Unmarshal(body, &entry) != nil || now.After(entry.ExpiresAt) {
+			if err := os.Remove(path); err != nil {
+				log.Debugw("unable to prune cache entry", "path", path, "error", err)
+			}
+		}
+	}
+}
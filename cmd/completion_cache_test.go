@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// TestCompletionTimeout tests the configurable completion deadline
+func TestCompletionTimeout(t *testing.T) {
+	config := setupTestConfig(t, t.TempDir(), "tome-cli")
+
+	if got := config.CompletionTimeout(); got != defaultCompletionTimeout {
+		t.Errorf("expected default timeout, got %s", got)
+	}
+	t.Setenv("TOME_CLI_COMPLETION_TIMEOUT", "250ms")
+	if got := config.CompletionTimeout(); got != 250*time.Millisecond {
+		t.Errorf("expected 250ms, got %s", got)
+	}
+	t.Setenv("TOME_CLI_COMPLETION_TIMEOUT", "soon")
+	if got := config.CompletionTimeout(); got != defaultCompletionTimeout {
+		t.Errorf("expected default timeout for invalid value, got %s", got)
+	}
+}
+
+// TestScriptCompletions tests running --completion with a deadline, stderr and caching
+func TestScriptCompletions(t *testing.T) {
+	t.Run("stderr is not a candidate", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/bash\n# TOME_COMPLETION\necho warning >&2\necho prod\n")
+
+		completions, _ := ValidArgsFunctionForScripts(nil, []string{"deploy"}, "")
+		if !reflect.DeepEqual(completions, []string{"prod"}) {
+			t.Errorf("expected only stdout candidates, got %q", completions)
+		}
+	})
+
+	t.Run("hung script times out", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		t.Setenv("TOME_CLI_COMPLETION_TIMEOUT", "200ms")
+		writeTestScript(t, filepath.Join(roots[0], "slow"), "#!/bin/bash\n# TOME_COMPLETION\nsleep 10\n")
+
+		start := time.Now()
+		_, directive := ValidArgsFunctionForScripts(nil, []string{"slow"}, "")
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("expected completion to give up quickly, took %s", elapsed)
+		}
+		if directive != cobra.ShellCompDirectiveError {
+			t.Errorf("expected error directive, got %d", directive)
+		}
+	})
+
+	t.Run("results are cached per args", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		counter := filepath.Join(t.TempDir(), "count")
+		writeTestScript(t, filepath.Join(roots[0], "cloud"), `#!/bin/bash
+# TOME_COMPLETION
+# TOME_COMPLETION_CACHE=1m
+echo run >> `+counter+`
+echo "$(wc -l < `+counter+` | tr -d ' ')"
+`)
+
+		first, _ := ValidArgsFunctionForScripts(nil, []string{"cloud"}, "")
+		second, _ := ValidArgsFunctionForScripts(nil, []string{"cloud"}, "")
+		if !reflect.DeepEqual(first, []string{"1"}) || !reflect.DeepEqual(second, first) {
+			t.Errorf("expected cached result [1] twice, got %q and %q", first, second)
+		}
+
+		other, _ := ValidArgsFunctionForScripts(nil, []string{"cloud"}, "x")
+		if !reflect.DeepEqual(other, []string{"2"}) {
+			t.Errorf("expected a different current word to miss the cache, got %q", other)
+		}
+	})
+
+	t.Run("expired entries are pruned on write", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "cloud"), "#!/bin/bash\n# TOME_COMPLETION\n# TOME_COMPLETION_CACHE=1m\necho prod\n")
+		cacheDir, err := tomeCacheDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := filepath.Join(cacheDir, "completions", "expired.json")
+		writeTestScript(t, expired, `{"expires_at":"2000-01-01T00:00:00Z"}`)
+
+		ValidArgsFunctionForScripts(nil, []string{"cloud"}, "")
+		if _, err := os.Stat(expired); !os.IsNotExist(err) {
+			t.Errorf("expected the expired entry to be removed, got %v", err)
+		}
+		if files, _ := os.ReadDir(filepath.Dir(expired)); len(files) != 1 {
+			t.Errorf("expected only the fresh entry to remain, got %d files", len(files))
+		}
+	})
+
+	t.Run("completing from another script times out", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		t.Setenv("TOME_CLI_COMPLETION_TIMEOUT", "200ms")
+		writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/bash\n# TOME_COMPLETE 1: script slow\n")
+		writeTestScript(t, filepath.Join(roots[0], "slow"), "#!/bin/bash\nsleep 10\n")
+
+		start := time.Now()
+		_, directive := ValidArgsFunctionForScripts(nil, []string{"deploy"}, "")
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("expected completion to give up quickly, took %s", elapsed)
+		}
+		if directive != cobra.ShellCompDirectiveError {
+			t.Errorf("expected error directive, got %d", directive)
+		}
+	})
+
+	t.Run("only the exact marker enables script completions", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		cases := map[string]bool{
+			"# TOME_COMPLETION\n":          true,
+			"# TOME_COMPLETION=v2\n":       true,
+			"# TOME_COMPLETION_CACHE=1m\n": false,
+		}
+		for header, expected := range cases {
+			writeTestScript(t, filepath.Join(roots[0], "s"), "#!/bin/bash\n"+header)
+			if got := NewScript(filepath.Join(roots[0], "s"), roots[0]).HasCompletions(); got != expected {
+				t.Errorf("expected %v for %q, got %v", expected, header, got)
+			}
+		}
+	})
+
+	t.Run("ttl is read from the header", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "a"), "#!/bin/bash\n# TOME_COMPLETION_CACHE=90s\n")
+		writeTestScript(t, filepath.Join(roots[0], "b"), "#!/bin/bash\n# TOME_COMPLETION\n")
+
+		if ttl := NewScript(filepath.Join(roots[0], "a"), roots[0]).CompletionCacheTTL(); ttl != 90*time.Second {
+			t.Errorf("expected 90s, got %s", ttl)
+		}
+		if ttl := NewScript(filepath.Join(roots[0], "b"), roots[0]).CompletionCacheTTL(); ttl != 0 {
+			t.Errorf("expected no caching, got %s", ttl)
+		}
+	})
+}
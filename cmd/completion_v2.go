@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// CompletionV2Marker opts a script into the JSON completion protocol.
+// The script is still called with --completion and TOME_COMPLETION set to the
+// arguments, TOME_COMPLETION_PROTOCOL=v2 is added and stdout must be a CompletionResponse:
+//
+//	{
+//	  "completions": ["plain", {"value": "prod", "description": "Production"}],
+//	  "directives": ["nospace", "keeporder"],
+//	  "active_help": ["Pick the environment to deploy to"]
+//	}
+const CompletionV2Marker = "TOME_COMPLETION=v2"
+
+// CompletionResponse is the v2 completion protocol output of a script
+type CompletionResponse struct {
+	Completions []CompletionCandidate `json:"completions"`
+	Directives  []string              `json:"directives"`
+	// Extensions filter files when the ext directive is given, e.g. ["yaml", "yml"]
+	Extensions []string `json:"extensions"`
+	// Directory limits the dirs directive to a subdirectory
+	Directory  string   `json:"directory"`
+	ActiveHelp []string `json:"active_help"`
+}
+
+// CompletionCandidate is a completion value with an optional description.
+// Candidates may be given as plain strings or objects.
+type CompletionCandidate struct {
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+func (c *CompletionCandidate) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		c.Value = value
+		return nil
+	}
+	type candidate CompletionCandidate
+	return json.Unmarshal(data, (*candidate)(c))
+}
+
+// completionDirectives maps protocol directive names onto cobra's directives
+var completionDirectives = map[string]cobra.ShellCompDirective{
+	"default":   cobra.ShellCompDirectiveDefault,
+	"files":     cobra.ShellCompDirectiveDefault,
+	"nofile":    cobra.ShellCompDirectiveNoFileComp,
+	"nospace":   cobra.ShellCompDirectiveNoSpace,
+	"keeporder": cobra.ShellCompDirectiveKeepOrder,
+	"dirs":      cobra.ShellCompDirectiveFilterDirs,
+	"ext":       cobra.ShellCompDirectiveFilterFileExt,
+	"error":     cobra.ShellCompDirectiveError,
+}
+
+// UsesCompletionV2 reports whether the script header opts into the JSON protocol
+func (s *Script) UsesCompletionV2() bool {
+	return strings.Contains(s.help, CompletionV2Marker)
+}
+
+// ParseCompletionResponse decodes the v2 protocol output of a script
+func ParseCompletionResponse(output []byte) (*CompletionResponse, error) {
+	response := &CompletionResponse{}
+	if err := json.Unmarshal(output, response); err != nil {
+		return nil, fmt.Errorf("invalid completion response: %w", err)
+	}
+	return response, nil
+}
+
+// Cobra converts the response into completions and a directive.
+// Without directives file completion is disabled, matching the line protocol.
+func (r *CompletionResponse) Cobra() ([]string, cobra.ShellCompDirective) {
+	directive := cobra.ShellCompDirectiveNoFileComp
+	if len(r.Directives) > 0 {
+		directive = cobra.ShellCompDirectiveDefault
+	}
+	for _, name := range r.Directives {
+		d, ok := completionDirectives[strings.ToLower(name)]
+		if !ok {
+			log.Debugw("unknown completion directive", "directive", name)
+			continue
+		}
+		directive |= d
+	}
+
+	var completions []string
+	switch {
+	case directive&cobra.ShellCompDirectiveFilterFileExt != 0:
+		// Cobra reads the extensions from the completions
+		completions = append(completions, r.Extensions...)
+	case directive&cobra.ShellCompDirectiveFilterDirs != 0:
+		if r.Directory != "" {
+			completions = append(completions, r.Directory)
+		}
+	default:
+		for _, c := range r.Completions {
+			if c.Description != "" {
+				completions = append(completions, c.Value+"\t"+c.Description)
+			} else {
+				completions = append(completions, c.Value)
+			}
+		}
+	}
+
+	for _, help := range r.ActiveHelp {
+		completions = cobra.AppendActiveHelp(completions, help)
+	}
+	return completions, directive
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// TestCompletionResponse tests mapping the v2 protocol onto cobra
+func TestCompletionResponse(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+
+	tests := []struct {
+		name        string
+		output      string
+		completions []string
+		directive   cobra.ShellCompDirective
+	}{
+		{
+			name:        "candidates without directives disable files",
+			output:      `{"completions": ["plain", {"value": "prod", "description": "Production"}]}`,
+			completions: []string{"plain", "prod\tProduction"},
+			directive:   cobra.ShellCompDirectiveNoFileComp,
+		},
+		{
+			name:        "combined directives",
+			output:      `{"completions": ["a=", "b="], "directives": ["nospace", "keeporder", "nofile"]}`,
+			completions: []string{"a=", "b="},
+			directive:   cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveKeepOrder | cobra.ShellCompDirectiveNoFileComp,
+		},
+		{
+			name:        "file completion",
+			output:      `{"directives": ["files"]}`,
+			completions: nil,
+			directive:   cobra.ShellCompDirectiveDefault,
+		},
+		{
+			name:        "extension filtering",
+			output:      `{"directives": ["ext"], "extensions": ["yaml", "yml"]}`,
+			completions: []string{"yaml", "yml"},
+			directive:   cobra.ShellCompDirectiveFilterFileExt,
+		},
+		{
+			name:        "directory filtering",
+			output:      `{"directives": ["dirs"], "directory": "configs"}`,
+			completions: []string{"configs"},
+			directive:   cobra.ShellCompDirectiveFilterDirs,
+		},
+		{
+			name:        "active help",
+			output:      `{"completions": ["x"], "active_help": ["Pick one"]}`,
+			completions: []string{"x", cobra.AppendActiveHelp(nil, "Pick one")[0]},
+			directive:   cobra.ShellCompDirectiveNoFileComp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ParseCompletionResponse([]byte(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			completions, directive := response.Cobra()
+			if !reflect.DeepEqual(completions, tt.completions) {
+				t.Errorf("expected %q, got %q", tt.completions, completions)
+			}
+			if directive != tt.directive {
+				t.Errorf("expected directive %d, got %d", tt.directive, directive)
+			}
+		})
+	}
+
+	t.Run("invalid json", func(t *testing.T) {
+		if _, err := ParseCompletionResponse([]byte("a\tb\n")); err == nil {
+			t.Error("expected an error for line protocol output")
+		}
+	})
+}
+
+// TestCompletionV2Script tests a script speaking the v2 protocol end to end
+func TestCompletionV2Script(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	writeTestScript(t, filepath.Join(roots[0], "deploy"), `#!/bin/bash
+# USAGE: $0 <env> TOME_COMPLETION=v2
+echo "noise on stderr" >&2
+[ "$TOME_COMPLETION_PROTOCOL" = "v2" ] || exit 1
+echo '{"completions": [{"value": "prod", "description": "Production"}], "directives": ["nospace"]}'
+`)
+
+	s, _ := NewScriptRoots(NewConfig()).Resolve([]string{"deploy"})
+	if s == nil {
+		t.Fatal("expected deploy to resolve")
+	}
+	if s.Usage() != "<env>" {
+		t.Errorf("expected marker stripped from usage, got %q", s.Usage())
+	}
+
+	completions, directive := ValidArgsFunctionForScripts(nil, []string{"deploy"}, "")
+	if !reflect.DeepEqual(completions, []string{"prod\tProduction"}) {
+		t.Errorf("unexpected completions: %q", completions)
+	}
+	if directive != cobra.ShellCompDirectiveNoSpace {
+		t.Errorf("expected nospace directive, got %d", directive)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+)
+
+// ConfigSetting is one effective setting and where its value came from
+type ConfigSetting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Settings returns the effective value of every config key along with its source.
+// Each root and each variable of env is listed on its own.
+func (c *Config) Settings() []ConfigSetting {
+	var settings []ConfigSetting
+	for _, key := range configKeys {
+		switch key {
+		case "root":
+			settings = append(settings, c.rootSettings()...)
+		case "env":
+			settings = append(settings, c.envSettings()...)
+		case "executable":
+			name := c.ExecutableName()
+			if name == "" {
+				name = executableName
+			}
+			settings = append(settings, ConfigSetting{key, name, c.ConfigSource(key)})
+		case "ignore":
+			settings = append(settings, ConfigSetting{key, strings.Join(c.IgnoreGlobs(), ", "), c.ConfigSource(key)})
+		default:
+			settings = append(settings, ConfigSetting{key, c.EnvVarOrViperValue(key), c.ConfigSource(key)})
+		}
+	}
+	return settings
+}
+
+func (c *Config) rootSettings() []ConfigSetting {
+	var settings []ConfigSetting
+	project := c.ProjectRoot()
+	for _, root := range c.RootDirs() {
+		source := c.ConfigSource("root")
+		if project != nil && root == project.Dir {
+			source = "discovered " + project.Marker
+		}
+		settings = append(settings, ConfigSetting{"root", root, source})
+	}
+	return settings
+}
+
+func (c *Config) envSettings() []ConfigSetting {
+	sources := map[string]ConfigSetting{}
+	for _, file := range configFiles {
+		if env, ok := file.Values["env"].(map[string]interface{}); ok {
+			for name, value := range env {
+				sources[name] = ConfigSetting{"env." + name, fmt.Sprint(value), file.Scope + " " + file.Path}
+			}
+		}
+	}
+	var settings []ConfigSetting
+	for name, setting := range sources {
+		if _, ok := os.LookupEnv(name); ok {
+			setting.Source += ", overridden by the environment"
+		}
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+	return settings
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration of tome-cli",
+	Long: dedent.Dedent(`
+	Settings are read from, in order of precedence:
+
+	  1. flags such as --root
+	  2. variables named after the executable, e.g. KIT_ROOT
+	  3. TOME_ variables, e.g. TOME_ROOT
+	  4. tome.yaml of the project containing the working directory
+	  5. $XDG_CONFIG_HOME/tome-cli/config.yaml, ~/.config/tome-cli/config.yaml when unset
+	  6. defaults
+
+	Config files may set root, executable, discover, index, ignore,
+	completion_timeout, skip_hooks, hook_timeout, supervise, confirm_without_tty,
+	prompt, history, debug and env, a map of variables passed to scripts and
+	hooks unless already set in the environment.
+	The root of a tome.yaml names the project root, searched after configured roots.
+	A tome.yaml may only set root, ignore and completion_timeout, other keys
+	are reported and ignored.
+	`),
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration and where each value came from",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		for _, setting := range NewConfig().Settings() {
+			fmt.Fprintf(w, "%s\t%s\t(%s)\n", setting.Key, setting.Value, setting.Source)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,315 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobeam/stringy"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// UserConfigFile is read from $XDG_CONFIG_HOME/tome-cli/, or ~/.config/tome-cli/ when unset, e.g.
+//
+//	root: ~/scripts
+//	executable: kit
+//	ignore: [scratch/]
+//	completion_timeout: 2s
+//	hook_timeout: 30s
+//	env:
+//	  AWS_REGION: us-east-1
+const UserConfigFile = "config.yaml"
+
+// ConfigFile is a configuration file read at startup
+type ConfigFile struct {
+	Path   string
+	Scope  string // user or project
+	Values map[string]interface{}
+}
+
+// configFiles are the files read by LoadConfigFiles, lowest precedence first
+var configFiles []*ConfigFile
+
+// configKeys are the settings config files may define, in the order config show lists them
+var configKeys = []string{"root", "executable", "discover", "index", "ignore", "completion_timeout", "skip_hooks", "hook_timeout", "supervise", "confirm_without_tty", "prompt", "history", "env", "debug"}
+
+// projectConfigKeys are the settings a project's tome.yaml may define. A repository is not
+// trusted to change how tome-cli runs, e.g. to skip hooks or set BASH_ENV for every script,
+// so the rest is left to the user file.
+var projectConfigKeys = []string{"root", "ignore", "completion_timeout"}
+
+// configFlags are the flags setting a key, flags take precedence over everything else
+var configFlags = map[string]*pflag.Flag{}
+
+// bindConfigFlag binds flag to key in viper and records it as the flag setting key
+func bindConfigFlag(key string, flag *pflag.Flag) {
+	viper.BindPFlag(key, flag)
+	configFlags[key] = flag
+}
+
+// userConfigPath returns the path of the user's config file, which need not exist
+func userConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "tome-cli", UserConfigFile), nil
+}
+
+// projectConfigPath finds the tome.yaml of the project containing start, empty when there is none
+func projectConfigPath(start string) string {
+	found := ""
+	walkProject(start, func(dir string) (bool, error) {
+		if path := filepath.Join(dir, ProjectConfigFile); isFile(path) {
+			found = path
+		}
+		return found != "", nil
+	})
+	return found
+}
+
+// LoadConfigFiles reads the user config file and then the project's tome.yaml
+// into v, so the project overrides the user. Environment variables and flags
+// bound to v still take precedence over both.
+// Only projectConfigKeys are loaded from a tome.yaml, its root names the discovered project root instead.
+func LoadConfigFiles(v *viper.Viper) error {
+	configFiles = nil
+	var errs []error
+
+	if path, err := userConfigPath(); err != nil {
+		errs = append(errs, err)
+	} else {
+		file, err := readConfigFile(path, "user")
+		errs = append(errs, err)
+		if file != nil {
+			configFiles = append(configFiles, file)
+		}
+	}
+
+	// The user file may disable discovery, which covers project files too
+	if err := mergeConfigFiles(v); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if NewConfig().DiscoveryEnabled() {
+		if cwd, err := os.Getwd(); err == nil {
+			if path := projectConfigPath(cwd); path != "" {
+				file, err := readConfigFile(path, "project")
+				errs = append(errs, err)
+				if file != nil {
+					errs = append(errs, restrictProjectConfig(file))
+					configFiles = append(configFiles, file)
+				}
+			}
+		}
+	}
+
+	if err := mergeConfigFiles(v); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// restrictProjectConfig drops the keys a project file may not set, reporting each of them.
+// Its root is dropped silently as it names the discovered project root instead.
+func restrictProjectConfig(file *ConfigFile) error {
+	delete(file.Values, "root")
+	var keys []string
+	for key := range file.Values {
+		if !slices.Contains(projectConfigKeys, key) {
+			keys = append(keys, key)
+			delete(file.Values, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return fmt.Errorf("%s: ignoring %s, only %s may be set by a project", file.Path, strings.Join(keys, ", "), strings.Join(projectConfigKeys, ", "))
+}
+
+// mergeConfigFiles replaces the config of v with configFiles merged in order
+func mergeConfigFiles(v *viper.Viper) error {
+	merged := map[string]interface{}{}
+	for _, file := range configFiles {
+		for key, value := range file.Values {
+			if env, ok := value.(map[string]interface{}); ok {
+				if previous, ok := merged[key].(map[string]interface{}); ok {
+					combined := map[string]interface{}{}
+					for k, v := range previous {
+						combined[k] = v
+					}
+					for k, v := range env {
+						combined[k] = v
+					}
+					env = combined
+				}
+				value = env
+			}
+			merged[key] = value
+		}
+	}
+	body, err := yaml.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	v.SetConfigType("yaml")
+	return v.ReadConfig(bytes.NewReader(body))
+}
+
+// readConfigFile parses the config file at path, a missing file is not an error.
+// Relative roots are resolved against the file's directory.
+// Unknown keys are reported and dropped, the file is returned with the rest.
+func readConfigFile(path string, scope string) (*ConfigFile, error) {
+	body, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(body, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var errs []error
+	for key, value := range values {
+		switch {
+		case !slices.Contains(configKeys, key):
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", path, key))
+			delete(values, key)
+		case key == "env":
+			if _, ok := value.(map[string]interface{}); !ok {
+				errs = append(errs, fmt.Errorf("%s: env must map variable names to values", path))
+				delete(values, key)
+			}
+		case key == "root":
+			var roots []string
+			switch value := value.(type) {
+			case string:
+				roots = filepath.SplitList(value)
+			case []interface{}:
+				for _, item := range value {
+					roots = append(roots, fmt.Sprint(item))
+				}
+			}
+			for i, root := range roots {
+				roots[i] = resolveConfigPath(filepath.Dir(path), root)
+			}
+			values[key] = roots
+		}
+	}
+	log.Debugw("read config file", "path", path, "scope", scope)
+	return &ConfigFile{Path: path, Scope: scope, Values: values}, errors.Join(errs...)
+}
+
+// resolveConfigPath expands ~ and makes path relative to dir absolute
+func resolveConfigPath(dir string, path string) string {
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// flagChanged reports whether key was given as a flag on the command line
+func (c *Config) flagChanged(key string) bool {
+	flag, ok := configFlags[key]
+	return ok && flag.Changed
+}
+
+// envVarName returns the executable specific environment variable of key, e.g. KIT_ROOT
+func (c *Config) envVarName(key string) string {
+	return strings.ToUpper(stringy.New(executableName).SnakeCase().Get() + "_" + key)
+}
+
+// ConfigSource describes where the effective value of key comes from, in order of precedence:
+// a flag, the executable specific variable, the TOME_ variable, the project file,
+// the user file and finally the default
+func (c *Config) ConfigSource(key string) string {
+	if c.flagChanged(key) {
+		return "flag --" + configFlags[key].Name
+	}
+	for _, name := range []string{c.envVarName(key), "TOME_" + strings.ToUpper(key)} {
+		if os.Getenv(name) != "" {
+			return "env " + name
+		}
+	}
+	for i := len(configFiles) - 1; i >= 0; i-- {
+		if _, ok := configFiles[i].Values[key]; ok {
+			return configFiles[i].Scope + " " + configFiles[i].Path
+		}
+	}
+	return "default"
+}
+
+// ConfigEnv returns the env of the config files for scripts and their hooks,
+// variables already set in the environment are left alone
+func (c *Config) ConfigEnv() []string {
+	vars := map[string]string{}
+	for _, file := range configFiles {
+		if env, ok := file.Values["env"].(map[string]interface{}); ok {
+			for name, value := range env {
+				vars[name] = fmt.Sprint(value)
+			}
+		}
+	}
+	var env []string
+	for name, value := range vars {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// IgnoreGlobs returns the ignore patterns configured in addition to each root's .tomeignore
+func (c *Config) IgnoreGlobs() []string {
+	if v, ok := c.EnvVarWithSuffix("ignore"); ok && !c.flagChanged("ignore") {
+		return strings.Fields(v)
+	}
+	return viper.GetViper().GetStringSlice("ignore")
+}
+
+// SkipHooks reports whether hooks are skipped, by --skip-hooks or skip_hooks
+func (c *Config) SkipHooks() bool {
+	skip, _ := strconv.ParseBool(c.EnvVarOrViperValue("skip_hooks"))
+	return skip
+}
+
+// HookTimeout returns the timeout of hooks which do not declare one, zero waits forever
+func (c *Config) HookTimeout() time.Duration {
+	raw := c.EnvVarOrViperValue("hook_timeout")
+	if raw == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout < 0 {
+		log.Debugw("invalid hook timeout, hooks wait forever", "value", raw, "error", err)
+		return 0
+	}
+	return timeout
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// setupTestConfigFiles writes a user config file and a project tome.yaml,
+// changes into the project and loads both
+func setupTestConfigFiles(t *testing.T, user, project string) (string, string, error) {
+	t.Helper()
+	setupTestConfig(t, "", "tome-cli")
+	viper.Set("root", nil)
+	// As initConfig does, so TOME_ variables rank above the files
+	viper.SetEnvPrefix("TOME")
+	viper.AutomaticEnv()
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	writeTestScript(t, filepath.Join(configHome, "tome-cli", UserConfigFile), user)
+
+	projectDir, _ := filepath.EvalSymlinks(t.TempDir())
+	if err := os.Mkdir(filepath.Join(projectDir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestScript(t, filepath.Join(projectDir, ProjectConfigFile), project)
+	chdirTest(t, projectDir)
+
+	t.Cleanup(func() {
+		configFiles = nil
+		mergeConfigFiles(viper.GetViper())
+	})
+	err := LoadConfigFiles(viper.GetViper())
+	return filepath.Join(configHome, "tome-cli"), projectDir, err
+}
+
+// TestConfigFiles tests the precedence of config files and environment variables
+func TestConfigFiles(t *testing.T) {
+	t.Run("project overrides user and env overrides both", func(t *testing.T) {
+		_, projectDir, err := setupTestConfigFiles(t, "completion_timeout: 3s\nhook_timeout: 1m\n", "completion_timeout: 2s\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := NewConfig()
+		if config.CompletionTimeout().String() != "2s" || config.HookTimeout().String() != "1m0s" {
+			t.Errorf("expected 2s and 1m, got %s and %s", config.CompletionTimeout(), config.HookTimeout())
+		}
+		if source := config.ConfigSource("completion_timeout"); source != "project "+filepath.Join(projectDir, ProjectConfigFile) {
+			t.Errorf("expected the project file as source, got %s", source)
+		}
+
+		t.Setenv("TOME_COMPLETION_TIMEOUT", "1s")
+		if config.CompletionTimeout().String() != "1s" || config.ConfigSource("completion_timeout") != "env TOME_COMPLETION_TIMEOUT" {
+			t.Errorf("expected TOME_COMPLETION_TIMEOUT to win, got %s", config.CompletionTimeout())
+		}
+		t.Setenv("TOME_CLI_COMPLETION_TIMEOUT", "4s")
+		if config.CompletionTimeout().String() != "4s" || config.ConfigSource("completion_timeout") != "env TOME_CLI_COMPLETION_TIMEOUT" {
+			t.Errorf("expected TOME_CLI_COMPLETION_TIMEOUT to win, got %s", config.CompletionTimeout())
+		}
+	})
+
+	t.Run("user roots come before the project root and resolve against the file", func(t *testing.T) {
+		userDir, projectDir, err := setupTestConfigFiles(t, "root: [scripts, /opt/team]\n", "root: tools\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{filepath.Join(userDir, "scripts"), "/opt/team", filepath.Join(projectDir, "tools")}
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("project root without a user root", func(t *testing.T) {
+		_, projectDir, err := setupTestConfigFiles(t, "", "root: tools\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{filepath.Join(projectDir, "tools")}
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("env does not override the environment", func(t *testing.T) {
+		t.Setenv("TOME_TEST_SET", "outside")
+		_, _, err := setupTestConfigFiles(t, "env:\n  TOME_TEST_SET: user\n  TOME_TEST_REGION: eu\n", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := NewConfig().ConfigEnv()
+		if !slices.Contains(env, "TOME_TEST_REGION=eu") || slices.ContainsFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "TOME_TEST_SET=") }) {
+			t.Errorf("expected only TOME_TEST_REGION, got %v", env)
+		}
+	})
+
+	t.Run("projects only set safe keys", func(t *testing.T) {
+		_, _, err := setupTestConfigFiles(t, "hook_timeout: 1m\n", "skip_hooks: true\nhook_timeout: 1s\nprompt: true\nignore: [scratch/]\nenv:\n  BASH_ENV: /tmp/evil\n")
+		if err == nil || !strings.Contains(err.Error(), "ignoring env, hook_timeout, prompt, skip_hooks") {
+			t.Errorf("expected the unsafe keys to be reported, got %v", err)
+		}
+		config := NewConfig()
+		if env := config.ConfigEnv(); slices.ContainsFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "BASH_ENV=") }) {
+			t.Errorf("expected the project's env to be ignored, got %v", env)
+		}
+		if config.SkipHooks() || config.HookTimeout().String() != "1m0s" || config.ConfigSource("skip_hooks") != "default" {
+			t.Errorf("expected the project's skip_hooks and hook_timeout to be ignored, got %v and %s", config.SkipHooks(), config.HookTimeout())
+		}
+		if got := config.IgnoreGlobs(); !reflect.DeepEqual(got, []string{"scratch/"}) {
+			t.Errorf("expected [scratch/], got %v", got)
+		}
+	})
+
+	t.Run("debug turns on debug logs", func(t *testing.T) {
+		if _, _, err := setupTestConfigFiles(t, "debug: true\n", ""); err != nil {
+			t.Fatal(err)
+		}
+		previous := log
+		t.Cleanup(func() {
+			debug = false
+			log = previous
+		})
+		initConfig()
+		if !debug {
+			t.Error("expected debug: true to enable debug logs")
+		}
+	})
+
+	t.Run("unknown keys are reported and the rest applies", func(t *testing.T) {
+		_, _, err := setupTestConfigFiles(t, "", "ignore: [scratch/]\nroots: /typo\n")
+		if err == nil || !strings.Contains(err.Error(), `unknown key "roots"`) {
+			t.Errorf("expected unknown key to be reported, got %v", err)
+		}
+		if got := NewConfig().IgnoreGlobs(); !reflect.DeepEqual(got, []string{"scratch/"}) {
+			t.Errorf("expected [scratch/], got %v", got)
+		}
+	})
+}
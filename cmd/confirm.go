@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfirmKey in a script's header asks for confirmation before it runs
+//
+//	# TOME_CONFIRM: "This will drop the {1} database"
+//
+// {N} is replaced by the Nth positional argument, options declared with OPTION: excluded.
+const ConfirmKey = "TOME_CONFIRM:"
+
+// DangerousMarker in a script's header makes confirming require typing the script's name
+const DangerousMarker = "TOME_DANGEROUS"
+
+// Policies for scripts needing confirmation when stdin is not a terminal, set by confirm_without_tty
+const (
+	ConfirmWithoutTTYFail    = "fail"
+	ConfirmWithoutTTYProceed = "proceed"
+)
+
+// confirmPlaceholder matches the {N} placeholders of a confirmation message
+var confirmPlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// Confirmation is asked for before running a script declaring TOME_CONFIRM or TOME_DANGEROUS
+type Confirmation struct {
+	Message string // shown before asking
+	Expect  string // answer which confirms, empty to accept yes or y
+}
+
+// Confirmation returns what to ask before running the script with args, nil when it runs unasked
+func (s *Script) Confirmation(args []string) *Confirmation {
+	var confirmation *Confirmation
+	for _, line := range strings.Split(s.help, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ConfirmKey) {
+			message := strings.TrimSpace(strings.TrimPrefix(line, ConfirmKey))
+			if unquoted, err := strconv.Unquote(message); err == nil {
+				message = unquoted
+			}
+			confirmation = &Confirmation{Message: expandConfirmMessage(message, args)}
+			break
+		}
+	}
+	command := strings.Join(s.PathSegments(), " ")
+	if strings.Contains(s.help, DangerousMarker) {
+		if confirmation == nil {
+			confirmation = &Confirmation{Message: fmt.Sprintf("%s is marked as dangerous", command)}
+		}
+		confirmation.Expect = command
+	}
+	return confirmation
+}
+
+// expandConfirmMessage replaces {N} with the Nth argument, placeholders without one are kept
+func expandConfirmMessage(message string, args []string) string {
+	return confirmPlaceholder.ReplaceAllStringFunc(message, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholder[1 : len(placeholder)-1])
+		if n < 1 || n > len(args) {
+			return placeholder
+		}
+		return args[n-1]
+	})
+}
+
+// Ask prints the message to out and reads the answer from in, reporting whether it confirms
+func (c *Confirmation) Ask(in io.Reader, out io.Writer) bool {
+	fmt.Fprintln(out, c.Message)
+	if c.Expect != "" {
+		fmt.Fprintf(out, "Type %q to continue: ", c.Expect)
+	} else {
+		fmt.Fprint(out, "Continue? [y/N] ")
+	}
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if c.Expect != "" {
+		return answer == c.Expect
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// AssumeYes reports whether confirmations are skipped, by --yes, TOME_YES=true or {EXEC}_YES=true
+func (c *Config) AssumeYes() bool {
+	yes, _ := strconv.ParseBool(c.EnvVarOrViperValue("yes"))
+	return yes
+}
+
+// ConfirmWithoutTTY returns what to do with scripts needing confirmation
+// when there is no terminal to ask on, fail unless set to proceed
+func (c *Config) ConfirmWithoutTTY() string {
+	if c.EnvVarOrViperValue("confirm_without_tty") == ConfirmWithoutTTYProceed {
+		return ConfirmWithoutTTYProceed
+	}
+	return ConfirmWithoutTTYFail
+}
+
+// confirmRun asks for the confirmation a script needs before running with args,
+// returning an error when it must not run
+func confirmRun(config *Config, script *Script, args []string) error {
+	confirmation := script.Confirmation(args)
+	if confirmation == nil || config.AssumeYes() {
+		return nil
+	}
+	command := strings.Join(script.PathSegments(), " ")
+	if !isTerminal(os.Stdin) {
+		if config.ConfirmWithoutTTY() == ConfirmWithoutTTYProceed {
+			log.Debugw("running without confirmation, stdin is not a terminal", "script", command)
+			return nil
+		}
+		return fmt.Errorf("%s needs confirmation and stdin is not a terminal, pass --yes to run it", command)
+	}
+	if !confirmation.Ask(os.Stdin, os.Stderr) {
+		return fmt.Errorf("%s not confirmed, aborting", command)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestConfirmation tests the confirmation scripts declare in their header
+func TestConfirmation(t *testing.T) {
+	cases := []struct {
+		name     string
+		header   string
+		args     []string
+		expected *Confirmation
+	}{
+		{"none", "# USAGE: drop <db>\n", []string{"users"}, nil},
+		{"message with arguments", "# USAGE: drop <db>\n# TOME_CONFIRM: \"This will drop the {1} database\"\n", []string{"users"}, &Confirmation{Message: "This will drop the users database"}},
+		{"missing arguments are kept", "# USAGE: drop <db>\n# TOME_CONFIRM: Drop {1} on {2}?\n", []string{"users"}, &Confirmation{Message: "Drop users on {2}?"}},
+		{"dangerous", "# USAGE: drop <db>\n# TOME_DANGEROUS\n", nil, &Confirmation{Message: "db drop is marked as dangerous", Expect: "db drop"}},
+		{"dangerous with message", "# USAGE: drop <db>\n# TOME_DANGEROUS\n# TOME_CONFIRM: \"Drop {1}\"\n", []string{"users"}, &Confirmation{Message: "Drop users", Expect: "db drop"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			roots := setupTestRoots(t, 1)
+			path := filepath.Join(roots[0], "db", "drop")
+			writeTestScript(t, path, "#!/bin/sh\n"+tc.header)
+			if got := NewScript(path, roots[0]).Confirmation(tc.args); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestConfirmationAsk tests which answers confirm a run
+func TestConfirmationAsk(t *testing.T) {
+	cases := []struct {
+		name         string
+		confirmation Confirmation
+		answer       string
+		expected     bool
+	}{
+		{"yes", Confirmation{Message: "Drop?"}, "yes\n", true},
+		{"y", Confirmation{Message: "Drop?"}, "Y\n", true},
+		{"no", Confirmation{Message: "Drop?"}, "n\n", false},
+		{"no answer", Confirmation{Message: "Drop?"}, "", false},
+		{"command name", Confirmation{Message: "Drop?", Expect: "db drop"}, "db drop\n", true},
+		{"yes is not the command name", Confirmation{Message: "Drop?", Expect: "db drop"}, "yes\n", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if got := tc.confirmation.Ask(strings.NewReader(tc.answer), &out); got != tc.expected {
+				t.Errorf("expected %v for %q, got %v", tc.expected, tc.answer, got)
+			}
+			if !strings.HasPrefix(out.String(), "Drop?\n") {
+				t.Errorf("expected the message to be shown, got %q", out.String())
+			}
+		})
+	}
+}
+
+// TestConfirmRun tests --yes and the policy without a terminal
+func TestConfirmRun(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	config := setupTestConfig(t, roots[0], "tome-cli")
+	path := filepath.Join(roots[0], "drop")
+	writeTestScript(t, path, "#!/bin/sh\n# USAGE: drop <db>\n# TOME_DANGEROUS\n")
+	script := NewScript(path, roots[0])
+
+	stdin, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	previous := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = previous })
+
+	t.Run("fails without a terminal", func(t *testing.T) {
+		if err := confirmRun(config, script, nil); err == nil || !strings.Contains(err.Error(), "--yes") {
+			t.Errorf("expected an error suggesting --yes, got %v", err)
+		}
+	})
+
+	t.Run("proceeds without a terminal when configured", func(t *testing.T) {
+		t.Setenv("TOME_CLI_CONFIRM_WITHOUT_TTY", ConfirmWithoutTTYProceed)
+		if err := confirmRun(config, script, nil); err != nil {
+			t.Errorf("expected to proceed, got %v", err)
+		}
+	})
+
+	t.Run("yes skips the question", func(t *testing.T) {
+		t.Setenv("TOME_CLI_YES", "true")
+		if err := confirmRun(config, script, nil); err != nil {
+			t.Errorf("expected to run, got %v", err)
+		}
+	})
+
+	t.Run("--yes before the script's path", func(t *testing.T) {
+		setupTestRun(t)
+		output := filepath.Join(t.TempDir(), "output")
+		writeTestScript(t, path, "#!/bin/sh\n# USAGE: drop <db>\n# TOME_DANGEROUS\necho \"$*\" > "+output+"\n")
+		t.Cleanup(func() {
+			flag := rootCmd.PersistentFlags().Lookup("yes")
+			flag.Value.Set("false")
+			flag.Changed = false
+		})
+		executeRootCmd(t, "--yes", "drop", "users")
+		if got := strings.TrimSpace(string(mustReadFile(t, output))); got != "users" {
+			t.Errorf("expected the script to run with users, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigFile marks a project, its optional root key names the
+// scripts directory relative to the file, e.g.
+//
+//	root: tools/scripts
+const ProjectConfigFile = "tome.yaml"
+
+// ProjectRootMarker marks a directory as a root of scripts, commonly scripts/.tomeroot
+const ProjectRootMarker = ".tomeroot"
+
+// ProjectRootDir is used as the root when a project keeps its scripts in .tome/
+const ProjectRootDir = ".tome"
+
+// ProjectRoot is a root found by walking up from the working directory
+type ProjectRoot struct {
+	Dir    string // root of scripts
+	Marker string // file or directory which marked it
+}
+
+// DiscoverProjectRoot walks up from start looking for the scripts of the
+// project it is in. At each directory the markers are checked in order:
+//
+//	.tomeroot          the directory itself is the root
+//	.tome/             .tome is the root
+//	scripts/.tomeroot  scripts is the root
+//	tome.yaml          its root key, or the directory itself
+//
+// The walk stops at the top-level of the git repository containing start,
+// so scripts of an enclosing project are never picked up. Outside of a
+// repository it stays below $HOME, and outside of $HOME only start is checked.
+func DiscoverProjectRoot(start string) (*ProjectRoot, error) {
+	var root *ProjectRoot
+	err := walkProject(start, func(dir string) (bool, error) {
+		var err error
+		root, err = projectRootIn(dir)
+		return root != nil, err
+	})
+	return root, err
+}
+
+// walkProject calls visit for start and each of its parents until visit
+// reports it is done or the last directory of the project was visited
+func walkProject(start string, visit func(dir string) (bool, error)) error {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return err
+	}
+	last := projectTop(dir)
+	for {
+		if done, err := visit(dir); done || err != nil {
+			return err
+		}
+		if dir == last {
+			log.Debugw("stopped walking at project top-level", "dir", dir)
+			return nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// projectTop returns the highest directory walked up to from dir: the git top-level
+// containing it, else the directory directly below $HOME containing it, else dir itself
+func projectTop(dir string) string {
+	home, _ := os.UserHomeDir()
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Lstat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		if current == home || filepath.Dir(current) == current {
+			break
+		}
+	}
+	if home == "" {
+		return dir
+	}
+	rel, err := filepath.Rel(home, dir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dir
+	}
+	return filepath.Join(home, strings.Split(rel, string(filepath.Separator))[0])
+}
+
+// projectRootIn checks dir for the markers of a project root
+func projectRootIn(dir string) (*ProjectRoot, error) {
+	if isFile(filepath.Join(dir, ProjectRootMarker)) {
+		return &ProjectRoot{Dir: dir, Marker: filepath.Join(dir, ProjectRootMarker)}, nil
+	}
+	if isDir(filepath.Join(dir, ProjectRootDir)) {
+		return &ProjectRoot{Dir: filepath.Join(dir, ProjectRootDir), Marker: filepath.Join(dir, ProjectRootDir)}, nil
+	}
+	if marker := filepath.Join(dir, "scripts", ProjectRootMarker); isFile(marker) {
+		return &ProjectRoot{Dir: filepath.Join(dir, "scripts"), Marker: marker}, nil
+	}
+	configPath := filepath.Join(dir, ProjectConfigFile)
+	if !isFile(configPath) {
+		return nil, nil
+	}
+	body, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var project struct {
+		Root string `yaml:"root"`
+	}
+	if err := yaml.Unmarshal(body, &project); err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	root := dir
+	if project.Root != "" {
+		root = filepath.Join(dir, project.Root)
+		if filepath.IsAbs(project.Root) {
+			root = project.Root
+		}
+	}
+	return &ProjectRoot{Dir: root, Marker: configPath}, nil
+}
+
+// DiscoveryEnabled reports whether roots are looked up from the working directory
+func (c *Config) DiscoveryEnabled() bool {
+	return c.EnvVarOrViperValue("discover") != "false"
+}
+
+// discoveredRoots remembers the project root of each working directory,
+// as roots are looked up many times during a single run
+var discoveredRoots = map[string]*ProjectRoot{}
+
+// ProjectRoot discovers the root of the project containing the working directory,
+// nil when there is none or discovery is disabled.
+// An unreadable marker is reported and otherwise ignored.
+func (c *Config) ProjectRoot() *ProjectRoot {
+	if !c.DiscoveryEnabled() {
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	if root, ok := discoveredRoots[cwd]; ok {
+		return root
+	}
+
+	root, err := DiscoverProjectRoot(cwd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to discover project root: %v\n", err)
+	}
+	if root != nil {
+		log.Debugw("discovered project root", "root", root.Dir, "marker", root.Marker)
+	}
+	discoveredRoots[cwd] = root
+	return root
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
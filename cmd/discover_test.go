@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// chdirTest changes the working directory for the duration of the test
+func chdirTest(t *testing.T, dir string) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+// TestDiscoverProjectRoot tests finding a project's scripts from anywhere inside it
+func TestDiscoverProjectRoot(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+
+	cases := []struct {
+		name     string
+		markers  []string
+		expected string
+	}{
+		{"tomeroot marks the directory", []string{"tools/.tomeroot"}, "tools"},
+		{".tome directory", []string{".tome/deploy"}, ".tome"},
+		{"scripts with tomeroot", []string{"scripts/.tomeroot"}, "scripts"},
+		{"tome.yaml without root", []string{"tome.yaml"}, "."},
+		{"nearest marker wins", []string{".tome/deploy", "tools/.tomeroot"}, "tools"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			project := t.TempDir()
+			if err := os.Mkdir(filepath.Join(project, ".git"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, marker := range tc.markers {
+				writeTestScript(t, filepath.Join(project, marker), "")
+			}
+			start := filepath.Join(project, "tools", "nested")
+			if err := os.MkdirAll(start, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := DiscoverProjectRoot(start)
+			if err != nil || root == nil {
+				t.Fatalf("expected a project root, got %v (%v)", root, err)
+			}
+			if expected := filepath.Join(project, tc.expected); root.Dir != expected {
+				t.Errorf("expected %s, got %s", expected, root.Dir)
+			}
+		})
+	}
+
+	t.Run("tome.yaml names the root", func(t *testing.T) {
+		project := t.TempDir()
+		writeTestScript(t, filepath.Join(project, "tome.yaml"), "root: bin/scripts\n")
+		root, err := DiscoverProjectRoot(project)
+		if err != nil || root == nil || root.Dir != filepath.Join(project, "bin", "scripts") {
+			t.Errorf("expected bin/scripts, got %+v (%v)", root, err)
+		}
+	})
+
+	t.Run("stops at the git top-level", func(t *testing.T) {
+		outer := t.TempDir()
+		writeTestScript(t, filepath.Join(outer, ".tome", "deploy"), "")
+		repo := filepath.Join(outer, "repo")
+		if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		root, err := DiscoverProjectRoot(repo)
+		if err != nil || root != nil {
+			t.Errorf("expected no project root outside the repository, got %+v (%v)", root, err)
+		}
+	})
+	t.Run("stays below home outside a repository", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		writeTestScript(t, filepath.Join(home, ".tome", "deploy"), "")
+		start := filepath.Join(home, "work", "app")
+		if err := os.MkdirAll(start, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if root, err := DiscoverProjectRoot(start); err != nil || root != nil {
+			t.Errorf("expected markers in home not to be picked up, got %+v (%v)", root, err)
+		}
+
+		writeTestScript(t, filepath.Join(home, "work", ".tome", "deploy"), "")
+		if root, err := DiscoverProjectRoot(start); err != nil || root == nil || root.Dir != filepath.Join(home, "work", ".tome") {
+			t.Errorf("expected work/.tome, got %+v (%v)", root, err)
+		}
+	})
+
+	t.Run("only checks the start outside home and a repository", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		outer := t.TempDir()
+		writeTestScript(t, filepath.Join(outer, ".tome", "deploy"), "")
+		start := filepath.Join(outer, "nested")
+		if err := os.Mkdir(start, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if root, err := DiscoverProjectRoot(start); err != nil || root != nil {
+			t.Errorf("expected no project root, got %+v (%v)", root, err)
+		}
+		if root, err := DiscoverProjectRoot(outer); err != nil || root == nil {
+			t.Errorf("expected the start itself to be checked, got %+v (%v)", root, err)
+		}
+	})
+}
+
+// TestConfigProjectRoot tests the project root coming after configured roots
+func TestConfigProjectRoot(t *testing.T) {
+	project := t.TempDir()
+	writeTestScript(t, filepath.Join(project, "scripts", ".tomeroot"), "")
+	if err := os.MkdirAll(filepath.Join(project, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(project, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	project, _ = filepath.EvalSymlinks(project)
+	chdirTest(t, filepath.Join(project, "src"))
+
+	t.Run("configured roots come first", func(t *testing.T) {
+		setupTestConfig(t, "/global", "tome-cli")
+		expected := []string{"/global", filepath.Join(project, "scripts")}
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("executable variable comes first", func(t *testing.T) {
+		setupTestConfig(t, "", "tome-cli")
+		viper.Set("root", nil)
+		t.Setenv("TOME_CLI_ROOT", "/kit")
+		expected := []string{"/kit", filepath.Join(project, "scripts")}
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("project root without a configured root", func(t *testing.T) {
+		setupTestConfig(t, "", "tome-cli")
+		viper.Set("root", nil)
+		expected := []string{filepath.Join(project, "scripts")}
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("discovery can be disabled", func(t *testing.T) {
+		setupTestConfig(t, "", "tome-cli")
+		viper.Set("root", nil)
+		t.Setenv("TOME_CLI_DISCOVER", "false")
+		cwd, _ := os.Getwd()
+		if got := NewConfig().RootDirs(); !reflect.DeepEqual(got, []string{cwd}) {
+			t.Errorf("expected [%s], got %v", cwd, got)
+		}
+	})
+}
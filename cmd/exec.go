@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -20,108 +19,169 @@ import (
 
 func ExecRunE(cmd *cobra.Command, args []string) error {
 	config := NewConfig()
-	rootDir := config.RootDir()
 	if len(args) == 0 {
 		fmt.Println("No file specified")
 		os.Exit(1)
 	}
 
-	// Try joining one arg segment at a time and return the first one that exists and is executable
-	var maybeFile string
-	var executable string
-	var maybeArgs []string
-	for idx, arg := range args {
-		// Guard against first cycle through where maybeFile is empty
-		// but on subsequent cycles, we want to not double stack the root dir
-		var fileRoot string
-		if maybeFile == "" {
-			fileRoot = rootDir
-		} else {
-			fileRoot = maybeFile
+	roots := NewScriptRoots(config)
+	script, maybeArgs := roots.Resolve(args)
+	// Persist before exec replaces the process and deferred calls are lost
+	roots.Save()
+	if script == nil {
+		fmt.Printf("No executable file found for %s in %s\n", strings.Join(args, " "), strings.Join(roots.Dirs(), ", "))
+		os.Exit(1)
+	}
+	executable := script.path
+	// As given on the command line, before declared options are consumed
+	scriptArgs := append([]string{}, maybeArgs...)
+
+	// Declared options are consumed here and exported as TOME_OPT_ variables
+	var optionEnvs []string
+	if specs := script.DeclaredOptions(); len(specs) > 0 {
+		parsed, err := ParseOptions(specs, maybeArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+			script.WriteHelp(os.Stderr)
+			os.Exit(1)
 		}
-		maybeFile = path.Join(fileRoot, arg)
-		maybeArgs = args[idx+1:]
-		fileInfo, err := os.Stat(maybeFile)
-		if os.IsNotExist(err) {
-			fmt.Printf("File %s does not exist\n", maybeFile)
-			continue
+		optionEnvs, err = parsed.Env()
+		if err != nil {
+			fmt.Printf("Error encoding options: %v\n", err)
+			os.Exit(1)
 		}
+		maybeArgs = parsed.Args
+	}
 
+	// Required arguments left out are asked for when opted into and there is a terminal to ask on
+	if (config.PromptEnabled() || script.Prompts()) && isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+		answers, err := promptMissingArgs(config, roots, script, maybeArgs, os.Stdin, os.Stderr)
 		if err != nil {
-			fmt.Printf("Error checking file %s: %v\n", maybeFile, err)
-			continue
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-
-		if fileInfo.IsDir() {
-			continue
+		if len(answers) > 0 {
+			maybeArgs = append(maybeArgs, answers...)
+			scriptArgs = append(scriptArgs, answers...)
+			name := config.ExecutableName()
+			if name == "" {
+				name = executableName
+			}
+			fmt.Fprintf(os.Stderr, "running: %s\n", commandLine(name, script.PathSegments(), scriptArgs))
 		}
+	}
 
-		if isExecutableByOwner(fileInfo.Mode()) {
-			executable = maybeFile
-			break
+	if grammar := script.UsageGrammar(); grammar != nil && script.ValidatesArgs() {
+		if err := grammar.Validate(maybeArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+			script.WriteHelp(os.Stderr)
+			os.Exit(1)
 		}
 	}
-	if executable == "" {
-		fmt.Println("No executable file found")
-		os.Exit(1)
+
+	// Asked before anything runs, hooks included
+	if !dryRun {
+		if err := confirmRun(config, script, maybeArgs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	absRootDir, err := filepath.Abs(config.RootDir())
+	absRootDir, err := filepath.Abs(script.root)
 	if err != nil {
 		fmt.Printf("Error getting absolute path for root dir: %v\n", err)
 		os.Exit(1)
 	}
 
-	envs := []string{}
+	// Variables from config files come first so anything tome-cli sets wins
+	envs := config.ConfigEnv()
 	envs = append(envs, fmt.Sprintf("TOME_ROOT=%s", absRootDir))
+	envs = append(envs, fmt.Sprintf("TOME_ROOTS=%s", strings.Join(roots.Dirs(), string(filepath.ListSeparator))))
 	envs = append(envs, fmt.Sprintf("TOME_EXECUTABLE=%s", config.ExecutableName()))
 
 	// Inject the named arguments as well
 	executableAsEnvPrefix := strings.ToUpper(stringy.New(config.ExecutableName()).SnakeCase().Get())
 	envs = append(envs, fmt.Sprintf("%s_ROOT=%s", executableAsEnvPrefix, absRootDir))
 	envs = append(envs, fmt.Sprintf("%s_EXECUTABLE=%s", executableAsEnvPrefix, config.ExecutableName()))
+	envs = append(envs, optionEnvs...)
+
+	// Audited before any hook runs, a broken audit config may have been mandatory
+	var auditor *Auditor
+	if !dryRun {
+		auditor, err = NewAuditor(script)
+		if err == nil {
+			err = auditor.Start(script, scriptArgs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v, refusing to run %s\n", err, strings.Join(script.PathSegments(), " "))
+			os.Exit(1)
+		}
+	}
 
-	// Check for hooks and generate wrapper if needed
-	var execTarget string
-	var execArgs []string
+	// Hooks run natively before the script, sourced hooks may extend envs
+	var hooks, postHooks, failureHooks, ranHooks []Hook
+	hookRunner := NewHookRunner(config)
 
-	if !skipHooks {
-		hookRunner := NewHookRunner(config)
-		hooks, err := hookRunner.DiscoverHooks()
+	if !config.SkipHooks() {
+		hooks, err = hookRunner.DiscoverScriptHooks(HookPhasePre, script)
 		if err != nil {
 			fmt.Printf("Error discovering hooks: %v\n", err)
 			os.Exit(1)
 		}
-
-		if len(hooks) > 0 {
-			// Generate wrapper script content
-			wrapperContent, err := hookRunner.GenerateWrapperScriptContent(hooks, executable, maybeArgs)
+		postHooks, err = hookRunner.DiscoverScriptHooks(HookPhasePost, script)
+		if err != nil {
+			fmt.Printf("Error discovering hooks: %v\n", err)
+			os.Exit(1)
+		}
+		failureHooks, err = hookRunner.DiscoverScriptHooks(HookPhaseOnFailure, script)
+		if err != nil {
+			fmt.Printf("Error discovering hooks: %v\n", err)
+			os.Exit(1)
+		}
+		if dryRun {
+			environ := mergeEnv(os.Environ(), append(envs, hookRunner.hookEnv(executable, maybeArgs)...))
+			PrintHooks(os.Stdout, HookPhasePre, hooks, environ)
+			PrintHooks(os.Stdout, HookPhasePost, postHooks, environ)
+			PrintHooks(os.Stdout, HookPhaseOnFailure, failureHooks, environ)
+		} else if len(hooks) > 0 {
+			envs, ranHooks, err = hookRunner.RunPreHooks(hooks, executable, maybeArgs, envs)
 			if err != nil {
-				fmt.Printf("Error generating wrapper script: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				if err := auditor.Finish(&ExecResult{ExitCode: 1}, map[string][]Hook{HookPhasePre: ranHooks}); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				}
 				os.Exit(1)
 			}
+		}
+	}
+	execTarget := executable
+	execArgs := append([]string{executable}, maybeArgs...)
 
-			// Execute shell with inline script instead of script directly
-			shellPath, err := findShell()
-			if err != nil {
-				fmt.Printf("Error finding shell: %v\n", err)
-				os.Exit(1)
+	// Post and on-failure hooks and the audit log need the script's exit status, so the script
+	// runs as a child instead of replacing tome-cli, as it does when supervision is asked for
+	supervised := len(postHooks) > 0 || len(failureHooks) > 0 || auditor != nil || config.Supervise() || script.Supervised()
+
+	if supervised {
+		code := superviseOrLog(execTarget, execArgs, envs, func(result *ExecResult) {
+			ran := map[string][]Hook{HookPhasePre: ranHooks}
+			if result.Failed() {
+				ran[HookPhaseOnFailure] = hookRunner.RunAfterHooks(HookPhaseOnFailure, failureHooks, executable, maybeArgs, result, envs)
+			}
+			ran[HookPhasePost] = hookRunner.RunAfterHooks(HookPhasePost, postHooks, executable, maybeArgs, result, envs)
+			if err := auditor.Finish(result, ran); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			}
-			execTarget = shellPath
-			// Use basename of shell path for argv[0]
-			shellName := filepath.Base(shellPath)
-			execArgs = []string{shellName, "-c", wrapperContent}
-		} else {
-			// No hooks, execute script directly
-			execTarget = executable
-			execArgs = append([]string{executable}, maybeArgs...)
+			notifyExecObservers(script, scriptArgs, result)
+		})
+		if code != 0 {
+			os.Exit(code)
 		}
-	} else {
-		// Skip hooks, execute script directly
-		execTarget = executable
-		execArgs = append([]string{executable}, maybeArgs...)
+		return nil
 	}
 
+	if !dryRun {
+		notifyExecObservers(script, scriptArgs, nil)
+	}
 	execOrLog(execTarget, execArgs, envs)
 	return nil
 }
@@ -147,7 +207,7 @@ func execOrLog(arv0 string, argv []string, env []string) {
 		fmt.Printf("dry run:\nbinary: %s\nargs: %+v\nenv (injected):\n%+v\n", arv0, strings.Join(argv, " "), strings.Join(env, "\n"))
 		return
 	}
-	mergedEnv := append(os.Environ(), env...)
+	mergedEnv := mergeEnv(os.Environ(), env)
 
 	err := syscall.Exec(arv0, argv, mergedEnv)
 	// Exec should create new process, so we should never get here except on error
@@ -164,21 +224,63 @@ var execCmd = &cobra.Command{
 	Long: dedent.Dedent(`
 	Usage: tome-cli exec <path-to> <script> [args...]
 
-	The exec command executes a script file with the provided arguments.
+	The exec command executes a script file with the provided arguments,
+	in these steps:
+
+	1. The script is searched for in the root directories specified in the
+	   tome configuration flags or env vars. Paths are joined with the root
+	   directory, the intervening directories, and the script file name. When
+	   several roots are configured they are searched in order and the first
+	   root containing the script wins.
+
+	2. Options declared with OPTION: lines in the header, such as
+
+	     # OPTION: --env,-e <name> (required) Target environment
+
+	   are parsed and exported as TOME_OPT_ENV along with TOME_ARGS_JSON,
+	   while positional arguments are passed through to the script.
+	   Flags after the script's path are the script's, tome-cli's own
+	   flags go before it.
+
+	3. Scripts declaring TOME_PROMPT in their header, or every script with
+	   prompt: true in a config file or TOME_PROMPT=true, ask for the <name>
+	   and {a|b} arguments of their USAGE line which are left out when stdin
+	   is a terminal, offering the usage choices or the script's completions.
+	   The equivalent command line is printed before running.
+
+	4. Scripts declaring TOME_VALIDATE_ARGS in their header have their arguments
+	   checked against the USAGE line. Invalid invocations print the script's
+	   help and exit without running the script.
+
+	5. Scripts declaring TOME_CONFIRM: "message" in their header ask for
+	   confirmation, {1} in the message being the first argument. TOME_DANGEROUS
+	   requires typing the script's name instead of yes. --yes before the
+	   script's path or TOME_YES=true skip the question. Without a terminal to ask on such scripts fail, unless
+	   confirm_without_tty is set to proceed.
 
-	The exec command will search for the script file in the root directory
-	specified in the tome configuration flags or env vars. Paths will be
-	joined with the root directory, the intervening directories, and
-	the script file name.
+	6. TOME_ROOT, the root the script was found in, TOME_ROOTS, listing every
+	   root, and TOME_EXECUTABLE are injected into the environment, as well as
+	   the same variables prefixed with the executable name as an uppercased
+	   snake case string. If the executable name is 'kit' these are KIT_ROOT
+	   and KIT_EXECUTABLE.
 
-	When executed, the script will be become the tome-cli process through
-	the syscall.Exec function.
+	7. Roots with a .tome-audit.yaml record the run to its file, syslog or http
+	   sinks before any hook runs. When auditing is mandatory, scripts are
+	   refused if an event can't be written.
 
-	TOME_ROOT and TOME_EXECUTABLE are injected into the environment as well
-	as the executable name as an uppercased snake case string.
+	8. Pre-run hooks are read from .hooks.d/ in the root and in every directory
+	   down to the script's directory, root first, and run in that order.
+	   Executable hooks are run by tome-cli directly, only hooks ending in
+	   .source need a shell. Variables they export are passed on to the script.
+	   --dry-run lists the hooks which apply instead.
 
-	If the executable name is 'kit' the additional environment variables would be:
-	KIT_ROOT, KIT_EXECUTABLE.
+	9. The script replaces the tome-cli process. It runs as a supervised child
+	   process instead when post-run hooks (.hooks.d/post.d/) or on-failure hooks
+	   (.hooks.d/on-failure.d/) exist, when the run is recorded to the audit
+	   log, or with --supervise, supervise: true in a config file,
+	   TOME_SUPERVISE=true or TOME_SUPERVISE in the script's header. Signals are
+	   forwarded to it, the hooks run once it exits and tome-cli exits with the
+	   script's exit status, 128 plus the signal number for a killed script.
 		`),
 	RunE:              ExecRunE,
 	ValidArgsFunction: ValidArgsFunctionForScripts,
@@ -190,7 +292,10 @@ var skipHooks bool
 func init() {
 	execCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry run the exec command")
 	execCmd.Flags().BoolVar(&skipHooks, "skip-hooks", false, "Skip pre-execution hooks")
+	execCmd.Flags().Bool("supervise", false, "Run the script as a child of tome-cli instead of replacing it")
 	viper.BindPFlag("dry-run", execCmd.Flags().Lookup("dry-run"))
-	viper.BindPFlag("skip-hooks", execCmd.Flags().Lookup("skip-hooks"))
+	bindConfigFlag("skip_hooks", execCmd.Flags().Lookup("skip-hooks"))
+	bindConfigFlag("supervise", execCmd.Flags().Lookup("supervise"))
+	execCmd.Flags().SetInterspersed(false)
 	rootCmd.AddCommand(execCmd)
 }
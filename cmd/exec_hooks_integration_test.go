@@ -3,7 +3,7 @@ package cmd
 import (
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"testing"
 )
 
@@ -41,14 +41,16 @@ exit 0
 		_ = setupTestConfig(t, tmpDir, "tome-cli")
 
 		// Simulate exec command
+		// Dry run, as syscall.Exec would replace the test process
+		// and silently end the test run
 		skipHooks = false
+		dryRun = true
+		t.Cleanup(func() { dryRun = false })
 		err := ExecRunE(nil, []string{"test-script"})
 		if err != nil {
 			t.Fatalf("ExecRunE failed: %v", err)
 		}
 
-		// Note: ExecRunE uses syscall.Exec which replaces the process
-		// So we can't verify the output file in this test
 		// The integration tests in hooks_integration_test.go cover actual execution
 	})
 
@@ -81,7 +83,7 @@ exit 0
 		// Setup config
 		config := setupTestConfig(t, tmpDir, "tome-cli")
 
-		// Test hook discovery and wrapper generation
+		// Test hook discovery and running
 		skipHooks = false
 		hookRunner := NewHookRunner(config)
 		hooks, err := hookRunner.DiscoverHooks()
@@ -97,13 +99,13 @@ exit 0
 			t.Error("Hook should be marked as sourced")
 		}
 
-		wrapperContent, err := hookRunner.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
+		env, _, err := hookRunner.RunPreHooks(hooks, scriptPath, []string{}, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
-		// Verify wrapper contains source command
-		if !strings.Contains(wrapperContent, "source "+hookPath) {
-			t.Error("Wrapper should contain source command for .source hook")
+		// Verify the exported variable is passed on to the script
+		if !slices.Contains(env, "TEST_VAR=from_hook") {
+			t.Errorf("Expected TEST_VAR from the sourced hook, got %v", env)
 		}
 	})
 
@@ -237,7 +239,7 @@ exit 0
 		}
 	})
 
-	t.Run("exec passes script args to wrapper", func(t *testing.T) {
+	t.Run("exec passes script args to hooks", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		hooksDir := filepath.Join(tmpDir, ".hooks.d")
 		if err := os.Mkdir(hooksDir, 0755); err != nil {
@@ -264,20 +266,15 @@ exit 0
 			t.Fatal(err)
 		}
 
-		// Generate wrapper with args
+		// Run hooks with args
 		args := []string{"arg1", "arg2", "arg3"}
-		wrapperContent, err := hookRunner.GenerateWrapperScriptContent(hooks, scriptPath, args)
+		env, _, err := hookRunner.RunPreHooks(hooks, scriptPath, args, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
-		// Verify wrapper contains args
-		if !strings.Contains(wrapperContent,"exec "+scriptPath+" arg1 arg2 arg3") {
-			t.Error("Wrapper should contain script path and arguments")
-		}
-
 		// Verify environment variable is set
-		if !strings.Contains(wrapperContent,`TOME_SCRIPT_ARGS="arg1 arg2 arg3"`) {
-			t.Error("Wrapper should set TOME_SCRIPT_ARGS with all arguments")
+		if !slices.Contains(env, "TOME_SCRIPT_ARGS=arg1 arg2 arg3") {
+			t.Error("Hooks should get TOME_SCRIPT_ARGS with all arguments")
 		}
 	})
 }
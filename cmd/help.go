@@ -4,14 +4,19 @@ Copyright © 2024 Zander Hill <zander@xargs.io>
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/lithammer/dedent"
 	gitignore "github.com/sabhiram/go-gitignore"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var UsageKey = "USAGE: "
@@ -26,6 +31,9 @@ var helpCmd = &cobra.Command{
 
 	Help text is extracted from the script file by searching for the first line that includes "USAGE: ".
 
+	When given a directory, help prints the tree of commands beneath it with their usage.
+	A .description or README file in the directory provides the namespace's own summary.
+
 	When printing long form help text, the help command will print the help text from the script file
   starting from the line after the "USAGE: " line and ending on the first blank line.
 
@@ -41,31 +49,116 @@ var helpCmd = &cobra.Command{
 
 	In this example the USAGE line is "USAGE: script.sh [options] <arg1> <arg2>"
 	The help text is the lines following the USAGE line until the first blank line.
+
+	Headers split into sections (DESCRIPTION:, ARGUMENTS:, OPTIONS:, EXAMPLES:,
+	TAGS:, DEPRECATED: and OWNER:) are rendered with headings.
+
+	Use --output json or --output yaml to emit the command path, absolute path,
+	usage, help text, completion support, executable status and root of each
+	script for consumption by other tools.
 	`),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		config := NewConfig()
-		rootDir := config.RootDir()
-		ignorePatterns := config.IgnorePatterns()
+		roots := NewScriptRoots(config)
+		defer roots.Save()
+		if helpOutput != "text" {
+			return printStructuredHelp(cmd.OutOrStdout(), roots, args, helpOutput)
+		}
 		if len(args) == 0 {
-			allExecutables, err := collectExecutables(rootDir, ignorePatterns)
+			scripts, shadowed, err := roots.Collect()
 			if err != nil {
 				return err
 			}
-			for _, executable := range allExecutables {
-				s := NewScript(executable, rootDir)
-				s.PrintUsage()
+			for _, s := range scripts {
+				if roots.Multiple() {
+					s.WriteUsageWithRoot(cmd.OutOrStdout())
+				} else {
+					s.WriteUsage(cmd.OutOrStdout())
+				}
+			}
+			for _, sh := range shadowed {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s from %s is shadowed by %s\n", strings.Join(sh.Script.PathSegments(), " "), sh.Script.root, sh.ShadowedBy)
 			}
+		} else if roots.IsDir(args) {
+			printDirectoryHelp(cmd.OutOrStdout(), roots, args)
 		} else {
-			rootWithArgs := append([]string{rootDir}, args...)
-			filePath := path.Join(rootWithArgs...)
-			s := NewScript(filePath, rootDir)
-			s.PrintHelp()
+			s := lookupHelpScript(roots, args)
+			if roots.Multiple() {
+				fmt.Fprintf(cmd.OutOrStdout(), "root: %s\n", s.root)
+			}
+			s.WriteHelp(cmd.OutOrStdout())
 		}
 		return nil
 	},
 	ValidArgsFunction: ValidArgsFunctionForScripts,
 }
 
+var helpOutput string
+
+// lookupHelpScript finds the script or directory named by args in the merged roots
+func lookupHelpScript(roots *ScriptRoots, args []string) *Script {
+	s, _, root, err := roots.Lookup(args)
+	if err != nil {
+		// Preserve the historical behavior of describing the bare path in the primary root
+		root = roots.Dirs()[0]
+		return NewScript(path.Join(append([]string{root}, args...)...), root)
+	}
+	if s == nil {
+		return NewScript(path.Join(append([]string{root}, args...)...), root)
+	}
+	return s
+}
+
+// printDirectoryHelp renders the namespace summary followed by the tree of commands beneath it
+func printDirectoryHelp(out io.Writer, roots *ScriptRoots, args []string) {
+	tree := roots.Tree(args)
+	fmt.Fprintf(out, "%s\n---\n", strings.Join(args, " "))
+	if tree.Summary != "" {
+		fmt.Fprintf(out, "%s\n\n", tree.Summary)
+	}
+	if len(tree.Children) == 0 {
+		fmt.Fprintln(out, "No commands found")
+		return
+	}
+	fmt.Fprintln(out, strings.Join(args, " "))
+	tree.Render(out, roots.Multiple())
+}
+
+// printStructuredHelp emits the script listing, or a single script when args are given,
+// as json or yaml for tools such as launchers and dashboards
+func printStructuredHelp(out io.Writer, roots *ScriptRoots, args []string, format string) error {
+	var payload interface{}
+	if len(args) == 0 {
+		scripts, _, err := roots.Collect()
+		if err != nil {
+			return err
+		}
+		infos := make([]ScriptInfo, 0, len(scripts))
+		for _, s := range scripts {
+			infos = append(infos, s.Info())
+		}
+		payload = infos
+	} else {
+		payload = lookupHelpScript(roots, args).Info()
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		// Usage lines such as <arg1> must stay readable
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(payload)
+	case "yaml":
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(payload)
+	default:
+		return fmt.Errorf("unsupported output format %q, expected text, json or yaml", format)
+	}
+}
+
 // collectExecutables walks rootDir and returns paths to all executable files,
 // resolving symlinks to check the target's properties.
 // SYMLINK-001, SYMLINK-002: symlinked executables are included.
@@ -98,5 +191,6 @@ func collectExecutables(rootDir string, ignorePatterns *gitignore.GitIgnore) ([]
 }
 
 func init() {
+	helpCmd.Flags().StringVarP(&helpOutput, "output", "o", "text", "Output format: text, json or yaml")
 	rootCmd.AddCommand(helpCmd)
 }
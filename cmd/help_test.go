@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestStructuredHelp tests help --output json|yaml
+func TestStructuredHelp(t *testing.T) {
+	t.Run("json lists every script", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/bash\n# USAGE: $0 <env>\n# Deploys the app\n# TOME_COMPLETION\n")
+		writeTestScript(t, filepath.Join(roots[1], "db", "restore"), "#!/bin/bash\n# USAGE: $0 <backup>\n")
+
+		var buf bytes.Buffer
+		if err := printStructuredHelp(&buf, NewScriptRoots(NewConfig()), nil, "json"); err != nil {
+			t.Fatalf("printStructuredHelp() returned error: %v", err)
+		}
+
+		var infos []ScriptInfo
+		if err := json.Unmarshal(buf.Bytes(), &infos); err != nil {
+			t.Fatalf("invalid json output: %v\n%s", err, buf.String())
+		}
+		if len(infos) != 2 {
+			t.Fatalf("expected 2 scripts, got %d", len(infos))
+		}
+
+		expected := ScriptInfo{
+			Command:        []string{"deploy"},
+			Path:           filepath.Join(roots[0], "deploy"),
+			Usage:          "<env>",
+			Help:           "USAGE: $0 <env>\nDeploys the app\nTOME_COMPLETION",
+			HasCompletions: true,
+			Executable:     true,
+			Root:           roots[0],
+			Metadata:       &ScriptMetadata{Description: "Deploys the app"},
+		}
+		if !reflect.DeepEqual(infos[1], expected) {
+			t.Errorf("expected %+v, got %+v", expected, infos[1])
+		}
+		if !reflect.DeepEqual(infos[0].Command, []string{"db", "restore"}) || infos[0].Root != roots[1] {
+			t.Errorf("unexpected db restore entry: %+v", infos[0])
+		}
+	})
+
+	t.Run("yaml describes a single script", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "db", "restore"), "#!/bin/bash\n# USAGE: $0 <backup>\n")
+
+		var buf bytes.Buffer
+		if err := printStructuredHelp(&buf, NewScriptRoots(NewConfig()), []string{"db", "restore"}, "yaml"); err != nil {
+			t.Fatalf("printStructuredHelp() returned error: %v", err)
+		}
+
+		var info ScriptInfo
+		if err := yaml.Unmarshal(buf.Bytes(), &info); err != nil {
+			t.Fatalf("invalid yaml output: %v\n%s", err, buf.String())
+		}
+		if info.Usage != "<backup>" || !info.Executable || info.HasCompletions {
+			t.Errorf("unexpected script info: %+v", info)
+		}
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		setupTestRoots(t, 1)
+		var buf bytes.Buffer
+		if err := printStructuredHelp(&buf, NewScriptRoots(NewConfig()), nil, "xml"); err == nil {
+			t.Error("expected error for unsupported format")
+		}
+	})
+}
+
+// TestHelpWritesToCommand tests that script help goes to the command's writer
+func TestHelpWritesToCommand(t *testing.T) {
+	roots := setupTestRoots(t, 2)
+	writeTestScript(t, filepath.Join(roots[1], "db", "restore"), "#!/bin/bash\n# USAGE: $0 <backup>\n# Restores a backup\n")
+
+	var buf bytes.Buffer
+	helpCmd.SetOut(&buf)
+	t.Cleanup(func() { helpCmd.SetOut(nil) })
+
+	t.Run("script help", func(t *testing.T) {
+		buf.Reset()
+		if err := helpCmd.RunE(helpCmd, []string{"db", "restore"}); err != nil {
+			t.Fatal(err)
+		}
+		expected := "root: " + roots[1] + "\ndb restore\n---\n"
+		if !strings.HasPrefix(buf.String(), expected) || !strings.Contains(buf.String(), "Restores a backup") {
+			t.Errorf("expected help starting with %q, got %q", expected, buf.String())
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		buf.Reset()
+		if err := helpCmd.RunE(helpCmd, nil); err != nil {
+			t.Fatal(err)
+		}
+		expected := "db restore: <backup> [" + roots[1] + "]\n"
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected listing to contain %q, got %q", expected, buf.String())
+		}
+	})
+}
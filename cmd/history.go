@@ -0,0 +1,183 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NoHistoryMarker in a script's header keeps its runs out of the history,
+// for scripts taking secrets as arguments
+const NoHistoryMarker = "TOME_NO_HISTORY"
+
+// HistoryEntry is one run of a script as recorded in the history
+type HistoryEntry struct {
+	Command    []string  `json:"command"`
+	Args       []string  `json:"args"`
+	Executable string    `json:"executable"`
+	Root       string    `json:"root"`
+	Cwd        string    `json:"cwd"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"` // unknown when the script replaced tome-cli
+	Signal     string    `json:"signal,omitempty"`
+}
+
+// Failed reports whether the run is known to have failed
+func (e *HistoryEntry) Failed() bool {
+	return e.ExitCode != nil && *e.ExitCode != 0
+}
+
+// historyMaxSize bounds history.jsonl, once it grows past it the oldest runs
+// are dropped until half of it is left
+const historyMaxSize = 1 << 20
+
+// History is the append-only log of script runs, one JSON entry per line
+type History struct {
+	path string
+}
+
+// tomeStateDir returns $XDG_STATE_HOME/tome-cli, or ~/.local/state/tome-cli when unset
+func tomeStateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "tome-cli"), nil
+}
+
+func NewHistory() (*History, error) {
+	dir, err := tomeStateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &History{path: filepath.Join(dir, "history.jsonl")}, nil
+}
+
+// Append adds entry to the history in a single write, so concurrent runs never interleave
+func (h *History) Append(entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return h.trim()
+}
+
+// trim drops the oldest runs once the history outgrew historyMaxSize
+func (h *History) trim() error {
+	info, err := os.Stat(h.path)
+	if err != nil || info.Size() <= historyMaxSize {
+		return err
+	}
+	body, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	kept := len(lines)
+	for size := 0; kept > 0 && size+len(lines[kept-1]) <= historyMaxSize/2; kept-- {
+		size += len(lines[kept-1])
+	}
+	return writeFileAtomic(h.path, bytes.Join(lines[kept:], nil))
+}
+
+// Entries returns every recorded run, oldest first.
+// Lines which can't be parsed, such as a write cut short, are skipped.
+func (h *History) Entries() ([]HistoryEntry, error) {
+	file, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Debugw("skipping unreadable history entry", "path", h.path, "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// RecordsHistory reports whether runs of the script may be recorded
+func (s *Script) RecordsHistory() bool {
+	return !strings.Contains(s.help, NoHistoryMarker)
+}
+
+// HistoryEnabled reports whether runs are recorded, disabled by history: false or TOME_HISTORY=false
+func (c *Config) HistoryEnabled() bool {
+	return c.EnvVarOrViperValue("history") != "false"
+}
+
+// recordHistory appends a run of script to the history, failing to do so never affects the run
+func recordHistory(script *Script, args []string, result *ExecResult) {
+	config := NewConfig()
+	if !config.HistoryEnabled() || !script.RecordsHistory() {
+		return
+	}
+	entry := HistoryEntry{
+		Command:    script.PathSegments(),
+		Args:       append([]string{}, args...),
+		Executable: config.ExecutableName(),
+		Root:       script.root,
+		StartedAt:  time.Now(),
+	}
+	if entry.Executable == "" {
+		entry.Executable = executableName
+	}
+	entry.Cwd, _ = os.Getwd()
+	if result != nil {
+		exitCode := result.ExitCode
+		entry.ExitCode = &exitCode
+		entry.StartedAt = result.StartedAt
+		entry.DurationMs = result.Duration.Milliseconds()
+		if result.Signal != 0 {
+			entry.Signal = result.Signal.String()
+		}
+	}
+
+	history, err := NewHistory()
+	if err == nil {
+		err = history.Append(entry)
+	}
+	if err != nil {
+		log.Debugw("unable to record history", "error", err)
+	}
+}
+
+func init() {
+	ObserveExec(recordHistory)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	shellescape "al.essio.dev/pkg/shellescape"
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// HistoryFilter selects history entries
+type HistoryFilter struct {
+	Script string // command path such as "db restore" or "db/restore", matching it and below
+	Failed bool   // only runs known to have failed
+	Limit  int    // the most recent entries, zero for all
+}
+
+// NumberedEntry is a history entry numbered from the most recent run, which is 1
+type NumberedEntry struct {
+	Number int `json:"number"`
+	HistoryEntry
+}
+
+// Select returns the entries matching filter, oldest first, numbered across the whole history
+func (f HistoryFilter) Select(entries []HistoryEntry) []NumberedEntry {
+	script := strings.Join(strings.Fields(strings.ReplaceAll(f.Script, "/", " ")), " ")
+	var selected []NumberedEntry
+	for i, entry := range entries {
+		command := strings.Join(entry.Command, " ")
+		if script != "" && command != script && !strings.HasPrefix(command, script+" ") {
+			continue
+		}
+		if f.Failed && !entry.Failed() {
+			continue
+		}
+		selected = append(selected, NumberedEntry{Number: len(entries) - i, HistoryEntry: entry})
+	}
+	if f.Limit > 0 && len(selected) > f.Limit {
+		selected = selected[len(selected)-f.Limit:]
+	}
+	return selected
+}
+
+// CommandLine returns the entry as it would be typed, arguments quoted for the shell
+func (e *HistoryEntry) CommandLine() string {
+	return commandLine(e.Executable, e.Command, e.Args)
+}
+
+// commandLine returns the command running a script with args, quoted for the shell
+func commandLine(executable string, command []string, args []string) string {
+	return shellescape.QuoteCommand(append(append([]string{executable}, command...), args...))
+}
+
+// printHistory lists entries as number, start time, exit status, duration and command line
+func printHistory(out io.Writer, entries []NumberedEntry) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, entry := range entries {
+		status, duration := "-", "-"
+		if entry.ExitCode != nil {
+			status = strconv.Itoa(*entry.ExitCode)
+			duration = (time.Duration(entry.DurationMs) * time.Millisecond).String()
+		}
+		if entry.Signal != "" {
+			status += " (" + entry.Signal + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", entry.Number, entry.StartedAt.Local().Format("2006-01-02 15:04:05"), status, duration, entry.CommandLine())
+	}
+	return w.Flush()
+}
+
+var historyFilter HistoryFilter
+var historyJSON bool
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List previous runs of scripts",
+	Long: dedent.Dedent(`
+	Every run of a script is recorded with its arguments, working directory,
+	root and start time in $XDG_STATE_HOME/tome-cli/history.jsonl
+	(~/.local/state/tome-cli/history.jsonl when unset).
+
+	Supervised runs also record their duration and exit status, runs which
+	replaced tome-cli show - for both. See exec --supervise.
+
+	Runs are numbered from the most recent, which is 1, as used by rerun.
+	Scripts declaring TOME_NO_HISTORY in their header are never recorded,
+	history: false in a config file or TOME_HISTORY=false disables recording.
+	`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		history, err := NewHistory()
+		if err != nil {
+			return err
+		}
+		entries, err := history.Entries()
+		if err != nil {
+			return err
+		}
+		selected := historyFilter.Select(entries)
+		if historyJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			if selected == nil {
+				selected = []NumberedEntry{}
+			}
+			return encoder.Encode(selected)
+		}
+		return printHistory(cmd.OutOrStdout(), selected)
+	},
+}
+
+var rerunCmd = &cobra.Command{
+	Use:   "rerun [N]",
+	Short: "Run a script again with the arguments of a previous run",
+	Long: dedent.Dedent(`
+	Runs the script of history entry N again, 1 being the most recent run
+	and the default, from the working directory it was run in.
+
+	A run is refused when its script is now found in another root than the one
+	it ran from, pass --root with the recorded root to run it from there.
+	`),
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		number := 1
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid history entry %q, expected a number from 1", args[0])
+			}
+			number = n
+		}
+
+		history, err := NewHistory()
+		if err != nil {
+			return err
+		}
+		entries, err := history.Entries()
+		if err != nil {
+			return err
+		}
+		if number > len(entries) {
+			return fmt.Errorf("no history entry %d, %d runs recorded", number, len(entries))
+		}
+		entry := entries[len(entries)-number]
+
+		if entry.Cwd != "" {
+			if err := os.Chdir(entry.Cwd); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: running from %s instead: %v\n", workingDir(), err)
+			} else if err := LoadConfigFiles(viper.GetViper()); err != nil {
+				// The project's tome.yaml is the one of the directory it ran in
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+		if err := checkRerunRoot(NewConfig(), entry); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "rerunning: %s\n", entry.CommandLine())
+		return ExecRunE(cmd, append(append([]string{}, entry.Command...), entry.Args...))
+	},
+}
+
+// checkRerunRoot refuses an entry whose script now resolves in another root,
+// its arguments would be given to a different script
+func checkRerunRoot(config *Config, entry HistoryEntry) error {
+	script, _ := NewScriptRoots(config).Resolve(append(append([]string{}, entry.Command...), entry.Args...))
+	if script == nil || entry.Root == "" || filepath.Clean(script.root) == filepath.Clean(entry.Root) {
+		return nil
+	}
+	return fmt.Errorf("%s ran from %s but is now found in %s, pass --root %s to run it from there",
+		strings.Join(entry.Command, " "), entry.Root, script.root, entry.Root)
+}
+
+func workingDir() string {
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFilter.Script, "script", "", "only runs of this script or directory, e.g. \"db restore\"")
+	historyCmd.Flags().BoolVar(&historyFilter.Failed, "failed", false, "only runs which exited non-zero")
+	historyCmd.Flags().IntVarP(&historyFilter.Limit, "limit", "n", 20, "number of runs to show, 0 for all")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "print runs as JSON")
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(rerunCmd)
+}
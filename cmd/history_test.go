@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupTestHistory points the history at a temp state directory
+func setupTestHistory(t *testing.T) *History {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	history, err := NewHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+// TestHistory tests recording runs and selecting them again
+func TestHistory(t *testing.T) {
+	t.Run("records runs and skips unreadable lines", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		history := setupTestHistory(t)
+		writeTestScript(t, filepath.Join(roots[0], "db", "restore"), "#!/bin/sh\n")
+		writeTestScript(t, filepath.Join(roots[0], "login"), "#!/bin/sh\n# TOME_NO_HISTORY\n")
+
+		recordHistory(NewScript(filepath.Join(roots[0], "db", "restore"), roots[0]), []string{"a b", "$x"}, nil)
+		if err := os.WriteFile(history.path, append(mustReadFile(t, history.path), []byte("{cut short\n")...), 0600); err != nil {
+			t.Fatal(err)
+		}
+		recordHistory(NewScript(filepath.Join(roots[0], "db", "restore"), roots[0]), []string{"prod"}, &ExecResult{ExitCode: 1})
+		recordHistory(NewScript(filepath.Join(roots[0], "login"), roots[0]), []string{"hunter2"}, nil)
+
+		entries, err := history.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries, got %+v", entries)
+		}
+		if entries[0].ExitCode != nil || !reflect.DeepEqual(entries[0].Args, []string{"a b", "$x"}) {
+			t.Errorf("expected unknown exit code and args kept intact, got %+v", entries[0])
+		}
+		if entries[0].CommandLine() != `tome-cli db restore 'a b' '$x'` {
+			t.Errorf("expected a quoted command line, got %s", entries[0].CommandLine())
+		}
+		if !entries[1].Failed() {
+			t.Errorf("expected the supervised run to have failed, got %+v", entries[1])
+		}
+	})
+
+	t.Run("oldest runs are dropped once the history is too large", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		history := setupTestHistory(t)
+		padding := strings.Repeat("x", 1024)
+		for i := 0; i < 1100; i++ {
+			if err := history.Append(HistoryEntry{Command: []string{"old"}, Args: []string{padding}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		recordHistory(NewScript(filepath.Join(roots[0], "deploy"), roots[0]), nil, nil)
+
+		entries, err := history.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 || len(entries) >= 1000 || entries[len(entries)-1].Command[0] != "deploy" {
+			t.Fatalf("expected the oldest runs to be dropped and deploy kept, got %d runs", len(entries))
+		}
+		if info, _ := os.Stat(history.path); info.Size() > historyMaxSize {
+			t.Errorf("expected the history to stay below %d bytes, got %d", historyMaxSize, info.Size())
+		}
+	})
+
+	t.Run("history can be disabled", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		history := setupTestHistory(t)
+		t.Setenv("TOME_CLI_HISTORY", "false")
+		recordHistory(NewScript(filepath.Join(roots[0], "deploy"), roots[0]), nil, nil)
+		if entries, _ := history.Entries(); len(entries) != 0 {
+			t.Errorf("expected no entries, got %+v", entries)
+		}
+	})
+}
+
+// TestHistoryFilter tests numbering and filtering history entries
+func TestHistoryFilter(t *testing.T) {
+	failed := 2
+	entries := []HistoryEntry{
+		{Command: []string{"db", "restore"}, ExitCode: &failed},
+		{Command: []string{"db", "restore-all"}},
+		{Command: []string{"deploy"}},
+		{Command: []string{"db", "restore"}},
+	}
+	numbers := func(selected []NumberedEntry) []int {
+		var got []int
+		for _, entry := range selected {
+			got = append(got, entry.Number)
+		}
+		return got
+	}
+
+	cases := []struct {
+		name     string
+		filter   HistoryFilter
+		expected []int
+	}{
+		{"numbered from the most recent", HistoryFilter{}, []int{4, 3, 2, 1}},
+		{"script by path", HistoryFilter{Script: "db/restore"}, []int{4, 1}},
+		{"script by directory", HistoryFilter{Script: "db"}, []int{4, 3, 1}},
+		{"failed runs", HistoryFilter{Failed: true}, []int{4}},
+		{"limit keeps the most recent", HistoryFilter{Limit: 2}, []int{2, 1}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := numbers(tc.filter.Select(entries)); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// TestCheckRerunRoot tests refusing to rerun a script now found in another root
+func TestCheckRerunRoot(t *testing.T) {
+	roots := setupTestRoots(t, 2)
+	config := NewConfig()
+	writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/sh\n")
+	writeTestScript(t, filepath.Join(roots[1], "deploy"), "#!/bin/sh\n")
+
+	if err := checkRerunRoot(config, HistoryEntry{Command: []string{"deploy"}, Args: []string{"prod"}, Root: roots[0]}); err != nil {
+		t.Errorf("expected the same root to rerun, got %v", err)
+	}
+	err := checkRerunRoot(config, HistoryEntry{Command: []string{"deploy"}, Args: []string{"prod"}, Root: roots[1]})
+	if err == nil || !strings.Contains(err.Error(), "--root "+roots[1]) {
+		t.Errorf("expected a run from another root to be refused, got %v", err)
+	}
+}
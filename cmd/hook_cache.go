@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HookCacheEntry records when a successful hook run stops being reused
+type HookCacheEntry struct {
+	Hook      string    `json:"hook"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HookCache stores the successful run of a hook declaring TOME_HOOK_CACHE
+// under the user cache directory
+type HookCache struct {
+	path string
+	ttl  time.Duration
+	hook Hook
+}
+
+// hookCacheKeyInputs resolves a TOME_HOOK_CACHE_KEY input against the hook's environment
+var hookCacheKeyInputs = map[string]func(env map[string]string) string{
+	"dir":    func(env map[string]string) string { return filepath.Dir(env["TOME_SCRIPT_PATH"]) },
+	"script": func(env map[string]string) string { return env["TOME_SCRIPT_PATH"] },
+	"args":   func(env map[string]string) string { return env["TOME_SCRIPT_ARGS_JSON"] },
+	"cwd": func(env map[string]string) string {
+		wd, _ := os.Getwd()
+		return wd
+	},
+}
+
+// validateCacheKey reports cache key inputs which are neither env:NAME nor a known input
+func validateCacheKey(inputs []string) error {
+	for _, input := range inputs {
+		if strings.HasPrefix(input, "env:") {
+			continue
+		}
+		if _, ok := hookCacheKeyInputs[input]; !ok {
+			return fmt.Errorf("unknown cache key input %q, expected env:NAME, dir, script, args or cwd", input)
+		}
+	}
+	return nil
+}
+
+// NewHookCache returns the cache for running hook with environ, nil when the hook
+// is not cached. Sourced hooks are never cached as their exports would have to be stored.
+// The key includes the hook's modification time and size so edits invalidate it.
+func NewHookCache(hook Hook, environ []string) *HookCache {
+	if hook.Cache <= 0 || hook.Sourced {
+		return nil
+	}
+	info, err := os.Stat(hook.Path)
+	if err != nil {
+		return nil
+	}
+	cacheDir, err := tomeCacheDir()
+	if err != nil {
+		log.Debugw("unable to determine cache dir", "error", err)
+		return nil
+	}
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = value
+	}
+	inputs := []string{hook.Path, info.ModTime().String(), fmt.Sprint(info.Size())}
+	for _, input := range hook.CacheKey {
+		if name, ok := strings.CutPrefix(input, "env:"); ok {
+			inputs = append(inputs, input+"="+env[name])
+		} else if resolve, ok := hookCacheKeyInputs[input]; ok {
+			inputs = append(inputs, input+"="+resolve(env))
+		}
+	}
+
+	key, err := json.Marshal(inputs)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(key)
+	return &HookCache{
+		path: filepath.Join(cacheDir, "hooks", hex.EncodeToString(sum[:])+".json"),
+		ttl:  hook.Cache,
+		hook: hook,
+	}
+}
+
+// ExpiresAt returns when the cached run expires, zero when there is none
+func (c *HookCache) ExpiresAt() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	body, err := os.ReadFile(c.path)
+	if err != nil {
+		return time.Time{}
+	}
+	entry := HookCacheEntry{}
+	if err := json.Unmarshal(body, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		return time.Time{}
+	}
+	return entry.ExpiresAt
+}
+
+// Fresh reports whether a successful run may be reused
+func (c *HookCache) Fresh() bool {
+	return !c.ExpiresAt().IsZero()
+}
+
+// Put records a successful run, logging instead of failing because the cache is only an optimization
+func (c *HookCache) Put() {
+	if c == nil {
+		return
+	}
+	body, err := json.Marshal(HookCacheEntry{Hook: c.hook.Path, ExpiresAt: time.Now().Add(c.ttl)})
+	if err == nil {
+		err = writeFileAtomic(c.path, body)
+	}
+	if err != nil {
+		log.Debugw("unable to write hook cache", "path", c.path, "error", err)
+	}
+	pruneExpiredEntries(filepath.Dir(c.path))
+}
+
+// CacheStatus describes whether hook will run or reuse a cached run, empty for uncached hooks
+func (h Hook) CacheStatus(environ []string) string {
+	cache := NewHookCache(h, environ)
+	if cache == nil {
+		return ""
+	}
+	if expiresAt := cache.ExpiresAt(); !expiresAt.IsZero() {
+		return fmt.Sprintf("cached for %s", time.Until(expiresAt).Round(time.Second))
+	}
+	return "will run, not cached"
+}
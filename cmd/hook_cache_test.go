@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHookCache tests reusing successful hook runs within their TTL
+func TestHookCache(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	counter := filepath.Join(t.TempDir(), "count")
+	hookPath := filepath.Join(roots[0], ".hooks.d", "00-auth")
+	writeTestScript(t, hookPath, "#!/bin/sh\n# TOME_HOOK_CACHE: 1m\n# TOME_HOOK_CACHE_KEY: env:AWS_PROFILE, dir\necho x >> "+counter+"\n")
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "10-fail"), "#!/bin/sh\n# TOME_HOOK_CACHE: 1m\necho y >> "+counter+"\nexit 1\n")
+
+	hr := NewHookRunner(NewConfig())
+	script := NewScript(filepath.Join(roots[0], "aws", "deploy"), roots[0])
+	hooks, err := hr.DiscoverScriptHooks(HookPhasePre, script)
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("expected 2 hooks, got %+v (%v)", hooks, err)
+	}
+	runs := func() string {
+		body, _ := os.ReadFile(counter)
+		return string(body)
+	}
+	environ := func(profile string) []string {
+		return mergeEnv(os.Environ(), append(hr.hookEnv(script.path, nil), "AWS_PROFILE="+profile))
+	}
+
+	if status := hooks[0].CacheStatus(environ("dev")); status != "will run, not cached" {
+		t.Errorf("unexpected status before the first run: %q", status)
+	}
+	for i := 0; i < 2; i++ {
+		hr.RunHook(hooks[0], environ("dev"))
+		hr.RunHook(hooks[1], environ("dev"))
+	}
+	if runs() != "x\ny\ny\n" {
+		t.Errorf("expected the successful hook to run once and the failing one twice, got %q", runs())
+	}
+	if status := hooks[0].CacheStatus(environ("dev")); !strings.HasPrefix(status, "cached for") {
+		t.Errorf("unexpected status after a successful run: %q", status)
+	}
+	if _, ran, err := hr.RunHook(hooks[0], environ("dev")); ran || err != nil {
+		t.Errorf("expected a cached hook not to be reported as run, got %v (%v)", ran, err)
+	}
+
+	hr.RunHook(hooks[0], environ("prod"))
+	if strings.Count(runs(), "x") != 2 {
+		t.Errorf("expected a different cache key input to run the hook again, got %q", runs())
+	}
+
+	hr.skipCache = true
+	hr.RunHook(hooks[0], environ("dev"))
+	if strings.Count(runs(), "x") != 3 {
+		t.Errorf("expected skipping the cache to run the hook, got %q", runs())
+	}
+
+	t.Run("args tell apart arguments containing spaces", func(t *testing.T) {
+		hook := Hook{Path: hookPath, HookSettings: HookSettings{Cache: time.Minute, CacheKey: []string{"args"}}}
+		joined := NewHookCache(hook, hr.hookEnv(script.path, []string{"a b"}))
+		split := NewHookCache(hook, hr.hookEnv(script.path, []string{"a", "b"}))
+		if joined.path == split.path {
+			t.Error("expected different cache keys for \"a b\" and a b")
+		}
+	})
+
+	t.Run("expired entries are pruned", func(t *testing.T) {
+		expired := filepath.Join(filepath.Dir(NewHookCache(hooks[0], environ("dev")).path), "expired.json")
+		if err := os.WriteFile(expired, []byte(`{"expires_at":"2000-01-01T00:00:00Z"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		NewHookCache(hooks[0], environ("qa")).Put()
+		if _, err := os.Stat(expired); !os.IsNotExist(err) {
+			t.Errorf("expected the expired entry to be removed, got %v", err)
+		}
+	})
+
+	if err := os.WriteFile(hookPath, []byte("#!/bin/sh\n# TOME_HOOK_CACHE: 1m\n# TOME_HOOK_CACHE_KEY: env:AWS_PROFILE, dir\necho edited\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if status := hooks[0].CacheStatus(environ("dev")); status != "will run, not cached" {
+		t.Errorf("expected editing the hook to invalidate the cache, got %q", status)
+	}
+}
+
+// TestHookCacheSettings tests declarations which cannot be cached
+func TestHookCacheSettings(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+	if err := validateCacheKey([]string{"env:HOME", "dir", "script", "args", "cwd"}); err != nil {
+		t.Errorf("expected valid cache key inputs, got %v", err)
+	}
+	if err := validateCacheKey([]string{"hostname"}); err == nil {
+		t.Error("expected an error for an unknown cache key input")
+	}
+	if NewHookCache(Hook{Name: "00-env.source", Sourced: true, HookSettings: HookSettings{Cache: 60}}, nil) != nil {
+		t.Error("expected sourced hooks not to be cached")
+	}
+}
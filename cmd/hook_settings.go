@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HookSettingsFile declares settings for the hooks of its directory
+// without editing them, keyed by hook file name, e.g.
+//
+//	00-check-vpn:
+//	  timeout: 10s
+//	  retries: 2
+//	  allow_failure: true
+const HookSettingsFile = "hooks.yaml"
+
+// HookSettings are declared in a hook's header or in hooks.yaml
+type HookSettings struct {
+	Match        []string      `yaml:"match,omitempty"`         // globs relative to the hook's directory
+	Tags         []string      `yaml:"tags,omitempty"`          // the script needs one of them in its TAGS section
+	Timeout      time.Duration `yaml:"timeout,omitempty"`       // zero waits forever
+	Retries      int           `yaml:"retries,omitempty"`       // extra attempts after a failure
+	AllowFailure bool          `yaml:"allow_failure,omitempty"` // warn and continue instead of aborting
+	Cache        time.Duration `yaml:"cache,omitempty"`         // how long a successful run is reused
+	CacheKey     []string      `yaml:"cache_key,omitempty"`     // inputs which invalidate the cached result
+}
+
+// hookSettingsOverride is an entry of hooks.yaml. Its pointers tell an explicit
+// allow_failure: false or retries: 0 from a key left out.
+type hookSettingsOverride struct {
+	Match        []string       `yaml:"match"`
+	Tags         []string       `yaml:"tags"`
+	Timeout      *time.Duration `yaml:"timeout"`
+	Retries      *int           `yaml:"retries"`
+	AllowFailure *bool          `yaml:"allow_failure"`
+	Cache        *time.Duration `yaml:"cache"`
+	CacheKey     []string       `yaml:"cache_key"`
+}
+
+// hookDeclaration matches the header lines configuring a hook, e.g.
+//
+//	# TOME_HOOK_MATCH: deploy/**, db/migrate-*
+//	# TOME_HOOK_TAGS: prod
+//	# TOME_HOOK_TIMEOUT: 10s
+//	# TOME_HOOK_RETRIES: 2
+//	# TOME_HOOK_ALLOW_FAILURE
+//	# TOME_HOOK_CACHE: 15m
+//	# TOME_HOOK_CACHE_KEY: env:AWS_PROFILE, dir
+var hookDeclaration = regexp.MustCompile(`^#\s*TOME_HOOK_([A-Z_]+)(?::\s*(.*))?$`)
+
+// errHookTimedOut is returned when a hook exceeded its timeout
+var errHookTimedOut = errors.New("timed out")
+
+// parseHookHeader reads the TOME_HOOK_ declarations from the leading comment block
+// of a hook. Invalid declarations are reported while the valid ones are still returned.
+func parseHookHeader(path string) (HookSettings, error) {
+	settings := HookSettings{}
+	file, err := os.Open(path)
+	if err != nil {
+		return settings, err
+	}
+	defer file.Close()
+
+	var errs []error
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		declaration := hookDeclaration.FindStringSubmatch(line)
+		if declaration == nil {
+			continue
+		}
+		name, value := declaration[1], strings.TrimSpace(declaration[2])
+		values := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+		switch name {
+		case "MATCH":
+			settings.Match = append(settings.Match, values...)
+		case "TAGS":
+			settings.Tags = append(settings.Tags, values...)
+		case "TIMEOUT":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout < 0 {
+				errs = append(errs, fmt.Errorf("invalid TOME_HOOK_TIMEOUT %q", value))
+				continue
+			}
+			settings.Timeout = timeout
+		case "RETRIES":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				errs = append(errs, fmt.Errorf("invalid TOME_HOOK_RETRIES %q", value))
+				continue
+			}
+			settings.Retries = retries
+		case "ALLOW_FAILURE":
+			allow := true
+			if value != "" {
+				if allow, err = strconv.ParseBool(value); err != nil {
+					errs = append(errs, fmt.Errorf("invalid TOME_HOOK_ALLOW_FAILURE %q", value))
+					continue
+				}
+			}
+			settings.AllowFailure = allow
+		case "CACHE":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				errs = append(errs, fmt.Errorf("invalid TOME_HOOK_CACHE %q", value))
+				continue
+			}
+			settings.Cache = ttl
+		case "CACHE_KEY":
+			if err := validateCacheKey(values); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			settings.CacheKey = append(settings.CacheKey, values...)
+		default:
+			errs = append(errs, fmt.Errorf("unknown declaration TOME_HOOK_%s", name))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return settings, errors.Join(errs...)
+}
+
+// loadHookSettings reads hooks.yaml of a hooks directory, if there is one
+func loadHookSettings(hooksDir string) (map[string]hookSettingsOverride, error) {
+	body, err := os.ReadFile(filepath.Join(hooksDir, HookSettingsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]hookSettingsOverride{}
+	if err := yaml.Unmarshal(body, &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// merge returns s with the values set in override taking precedence, negative numbers are ignored
+func (s HookSettings) merge(override hookSettingsOverride) HookSettings {
+	if len(override.Match) > 0 {
+		s.Match = override.Match
+	}
+	if len(override.Tags) > 0 {
+		s.Tags = override.Tags
+	}
+	if override.Timeout != nil && *override.Timeout >= 0 {
+		s.Timeout = *override.Timeout
+	}
+	if override.Retries != nil && *override.Retries >= 0 {
+		s.Retries = *override.Retries
+	}
+	if override.AllowFailure != nil {
+		s.AllowFailure = *override.AllowFailure
+	}
+	if override.Cache != nil && *override.Cache >= 0 {
+		s.Cache = *override.Cache
+	}
+	if len(override.CacheKey) > 0 {
+		s.CacheKey = override.CacheKey
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestHookSettings tests reading hook settings from headers and hooks.yaml
+func TestHookSettings(t *testing.T) {
+	t.Run("header declarations", func(t *testing.T) {
+		setupTestConfig(t, t.TempDir(), "tome-cli")
+		path := filepath.Join(t.TempDir(), "00-vpn")
+		writeTestScript(t, path, `#!/bin/sh
+# TOME_HOOK_MATCH: deploy/**
+# TOME_HOOK_TIMEOUT: 10s
+# TOME_HOOK_RETRIES: 2
+# TOME_HOOK_ALLOW_FAILURE
+# TOME_HOOK_BOGUS: 1
+ping -c1 vpn
+# TOME_HOOK_RETRIES: 9
+`)
+
+		settings, err := parseHookHeader(path)
+		if err == nil || err.Error() != "unknown declaration TOME_HOOK_BOGUS" {
+			t.Errorf("expected unknown declaration error, got %v", err)
+		}
+		expected := HookSettings{Match: []string{"deploy/**"}, Timeout: 10 * time.Second, Retries: 2, AllowFailure: true}
+		if !reflect.DeepEqual(settings, expected) {
+			t.Errorf("expected %+v, got %+v", expected, settings)
+		}
+	})
+
+	t.Run("hooks.yaml overrides the header", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		hooksDir := filepath.Join(roots[0], ".hooks.d")
+		writeTestScript(t, filepath.Join(hooksDir, "00-vpn"), "#!/bin/sh\n# TOME_HOOK_TIMEOUT: 10s\n# TOME_HOOK_RETRIES: 1\n")
+		writeTestScript(t, filepath.Join(hooksDir, "10-other"), "#!/bin/sh\n")
+		if err := os.WriteFile(filepath.Join(hooksDir, HookSettingsFile), []byte("00-vpn:\n  timeout: 2s\n  allow_failure: true\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		hooks, err := NewHookRunner(NewConfig()).DiscoverHooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hooks) != 2 {
+			t.Fatalf("expected hooks.yaml not to be a hook, got %+v", hooks)
+		}
+		expected := HookSettings{Timeout: 2 * time.Second, Retries: 1, AllowFailure: true}
+		if !reflect.DeepEqual(hooks[0].HookSettings, expected) {
+			t.Errorf("expected %+v, got %+v", expected, hooks[0].HookSettings)
+		}
+		if !reflect.DeepEqual(hooks[1].HookSettings, HookSettings{}) {
+			t.Errorf("expected no settings for 10-other, got %+v", hooks[1].HookSettings)
+		}
+	})
+
+	t.Run("hooks.yaml turns header settings off", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		hooksDir := filepath.Join(roots[0], ".hooks.d")
+		writeTestScript(t, filepath.Join(hooksDir, "00-vpn"), "#!/bin/sh\n# TOME_HOOK_RETRIES: 3\n# TOME_HOOK_ALLOW_FAILURE\n# TOME_HOOK_CACHE: 5m\n")
+		if err := os.WriteFile(filepath.Join(hooksDir, HookSettingsFile), []byte("00-vpn:\n  retries: 0\n  allow_failure: false\n  cache: -1m\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		hooks, err := NewHookRunner(NewConfig()).DiscoverHooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := HookSettings{Cache: 5 * time.Minute}
+		if len(hooks) != 1 || !reflect.DeepEqual(hooks[0].HookSettings, expected) {
+			t.Errorf("expected %+v, got %+v", expected, hooks)
+		}
+	})
+}
@@ -1,40 +1,208 @@
 package cmd
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
-	"text/template"
 
 	shellescape "al.essio.dev/pkg/shellescape"
 	"github.com/gobeam/stringy"
+	gitignore "github.com/sabhiram/go-gitignore"
 )
 
 type Hook struct {
 	Path    string
 	Name    string
-	Sourced bool // true if filename ends with .source
+	Sourced bool   // true if filename ends with .source
+	Scope   string // directory relative to the roots the hook applies to, empty for every script
+	HookSettings
+}
+
+// Matches reports whether the hook's TOME_HOOK_MATCH and TOME_HOOK_TAGS declarations
+// select script. Hooks without declarations select every script, with both
+// declared the script has to satisfy both.
+func (h Hook) Matches(script *Script) bool {
+	if len(h.Match) > 0 {
+		rel := filepath.ToSlash(script.PathWithoutRoot())
+		if h.Scope != "" {
+			rel = strings.TrimPrefix(rel, filepath.ToSlash(h.Scope)+"/")
+		}
+		if !gitignore.CompileIgnoreLines(h.Match...).MatchesPath(rel) {
+			return false
+		}
+	}
+	if len(h.Tags) > 0 {
+		for _, tag := range script.Metadata().Tags {
+			if slices.Contains(h.Tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Reason explains why the hook applies to a script
+func (h Hook) Reason() string {
+	if h.Scope == "" && len(h.Match) == 0 && len(h.Tags) == 0 {
+		return "root hook, applies to every script"
+	}
+	reasons := []string{"root hook"}
+	if h.Scope != "" {
+		reasons = []string{fmt.Sprintf("scoped to %s/", h.Scope)}
+	}
+	if len(h.Match) > 0 {
+		reasons = append(reasons, "matches "+strings.Join(h.Match, " "))
+	}
+	if len(h.Tags) > 0 {
+		reasons = append(reasons, "tagged "+strings.Join(h.Tags, " "))
+	}
+	return strings.Join(reasons, ", ")
 }
 
 type HookRunner struct {
-	rootDir string
-	config  *Config
+	rootDir   string
+	rootDirs  []string
+	config    *Config
+	skipCache bool // run hooks even when a cached run could be reused
 }
 
 func NewHookRunner(config *Config) *HookRunner {
 	return &HookRunner{
-		rootDir: config.RootDir(),
-		config:  config,
+		rootDir:  config.RootDir(),
+		rootDirs: config.RootDirs(),
+		config:   config,
 	}
 }
 
-// DiscoverHooks finds all hooks in .hooks.d/
+// Hook phases, pre-run hooks live directly in .hooks.d and the
+// others in a subdirectory named after the phase
+const (
+	HookPhasePre       = "pre"
+	HookPhasePost      = "post"
+	HookPhaseOnFailure = "on-failure"
+)
+
+// hookPhases lists the phases in the order they run
+var hookPhases = []string{HookPhasePre, HookPhaseOnFailure, HookPhasePost}
+
+// hookPhaseDirs maps a phase to its directory relative to a root
+var hookPhaseDirs = map[string]string{
+	HookPhasePre:       ".hooks.d",
+	HookPhasePost:      filepath.Join(".hooks.d", "post.d"),
+	HookPhaseOnFailure: filepath.Join(".hooks.d", "on-failure.d"),
+}
+
+// DiscoverHooks finds all pre-run hooks in .hooks.d/ of every root.
+// A hook in an earlier root shadows a hook with the same name in a later root.
 func (hr *HookRunner) DiscoverHooks() ([]Hook, error) {
-	hooksDir := filepath.Join(hr.rootDir, ".hooks.d")
+	return hr.DiscoverPhaseHooks(HookPhasePre)
+}
+
+// DiscoverPhaseHooks finds the hooks of a phase in .hooks.d/ of every root
+func (hr *HookRunner) DiscoverPhaseHooks(phase string) ([]Hook, error) {
+	hooks, err := hr.discoverScopeHooks(phase, "")
+	if err != nil {
+		return nil, err
+	}
+	log.Debugw("discovered hooks", "phase", phase, "count", len(hooks))
+	return hooks, nil
+}
+
+// DiscoverScriptHooks finds the hooks of a phase which apply to script, those in
+// .hooks.d/ of every directory from the root down to the script's directory.
+// They run in root-to-leaf order, sorted by name within each directory,
+// so aws/.hooks.d/00-login only runs for scripts under aws/.
+// Hooks declaring TOME_HOOK_MATCH or TOME_HOOK_TAGS are dropped unless they select script.
+func (hr *HookRunner) DiscoverScriptHooks(phase string, script *Script) ([]Hook, error) {
+	hooks := []Hook{}
+	for _, scope := range hookScopes(script) {
+		scopeHooks, err := hr.discoverScopeHooks(phase, scope)
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range scopeHooks {
+			if !hook.Matches(script) {
+				log.Debugw("hook does not match script", "phase", phase, "script", script.path, "path", hook.Path, "match", hook.Match, "tags", hook.Tags)
+				continue
+			}
+			log.Debugw("hook applies", "phase", phase, "script", script.path, "path", hook.Path, "reason", hook.Reason())
+			hooks = append(hooks, hook)
+		}
+	}
+
+	log.Debugw("discovered hooks", "phase", phase, "count", len(hooks))
+	return hooks, nil
+}
+
+// hookScopes lists the directories from the root down to the script's directory,
+// e.g. "", "aws" and "aws/ec2" for aws/ec2/list
+func hookScopes(script *Script) []string {
+	scopes := []string{""}
+	rel, err := filepath.Rel(script.root, filepath.Dir(script.path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return scopes
+	}
+	scope := ""
+	for _, segment := range strings.Split(rel, string(filepath.Separator)) {
+		scope = filepath.Join(scope, segment)
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// discoverScopeHooks merges the hooks of one directory across every root.
+// A hook in an earlier root shadows a hook with the same name in a later root.
+func (hr *HookRunner) discoverScopeHooks(phase, scope string) ([]Hook, error) {
+	seen := map[string]bool{}
+	hooks := []Hook{}
+	for _, root := range hr.rootDirs {
+		rootHooks, err := hr.discoverHooksIn(filepath.Join(root, scope, hookPhaseDirs[phase]))
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range rootHooks {
+			if seen[hook.Name] {
+				log.Debugw("hook shadowed by earlier root", "path", hook.Path)
+				continue
+			}
+			seen[hook.Name] = true
+			hook.Scope = scope
+			hooks = append(hooks, hook)
+		}
+	}
+
+	// Sort by name lexicographically (00- comes before 10-, etc.)
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Name < hooks[j].Name
+	})
+	return hooks, nil
+}
+
+// PrintHooks lists the hooks of a phase, why they apply and whether
+// they reuse a cached run when given environ, used by dry runs
+func PrintHooks(w io.Writer, phase string, hooks []Hook, environ []string) {
+	for _, hook := range hooks {
+		fmt.Fprintf(w, "hook (%s): %s (%s)%s\n", phase, hook.Path, hook.Reason(), formatCacheStatus(hook.CacheStatus(environ)))
+	}
+}
+
+// formatCacheStatus appends a non-empty cache status to a hook listing
+func formatCacheStatus(status string) string {
+	if status == "" {
+		return ""
+	}
+	return " [" + status + "]"
+}
 
+// discoverHooksIn finds the hooks of a single .hooks.d directory
+func (hr *HookRunner) discoverHooksIn(hooksDir string) ([]Hook, error) {
 	// Check if hooks directory exists
 	if _, err := os.Stat(hooksDir); os.IsNotExist(err) {
 		log.Debugw(".hooks.d directory not found", "path", hooksDir)
@@ -46,9 +214,14 @@ func (hr *HookRunner) DiscoverHooks() ([]Hook, error) {
 		return nil, fmt.Errorf("failed to read .hooks.d: %w", err)
 	}
 
+	dirSettings, err := loadHookSettings(hooksDir)
+	if err != nil {
+		log.Warnw("failed to read hook settings", "path", filepath.Join(hooksDir, HookSettingsFile), "error", err)
+	}
+
 	var hooks []Hook
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() || entry.Name() == HookSettingsFile {
 			continue
 		}
 
@@ -72,103 +245,128 @@ func (hr *HookRunner) DiscoverHooks() ([]Hook, error) {
 			}
 		}
 
+		settings, err := parseHookHeader(fullPath)
+		if err != nil {
+			log.Warnw("failed to read hook header", "path", fullPath, "error", err)
+		}
+		if declared, ok := dirSettings[name]; ok {
+			settings = settings.merge(declared)
+		}
+		if settings.Timeout == 0 {
+			settings.Timeout = hr.config.HookTimeout()
+		}
+
 		hook := Hook{
-			Path:    fullPath,
-			Name:    name,
-			Sourced: sourced,
+			Path:         fullPath,
+			Name:         name,
+			Sourced:      sourced,
+			HookSettings: settings,
 		}
 
 		hooks = append(hooks, hook)
 		log.Debugw("discovered hook", "path", fullPath, "sourced", hook.Sourced)
 	}
 
-	// Sort by name lexicographically (00- comes before 10-, etc.)
-	sort.Slice(hooks, func(i, j int) bool {
-		return hooks[i].Name < hooks[j].Name
-	})
-
-	log.Debugw("discovered hooks", "count", len(hooks))
 	return hooks, nil
 }
 
-const wrapperScriptTemplate = `set -e
-{{range .Env -}}
-export {{.}}
-{{end}}
-{{range .Hooks -}}
-# Hook: {{.Name}}
-{{if .Sourced -}}
-if ! source "{{.Path}}"; then
-  echo 'Error: pre-hook failed: {{.Name}} (sourcing failed)' >&2
-  exit 1
-fi
-{{else -}}
-if ! "{{.Path}}"; then
-  echo 'Error: pre-hook failed: {{.Name}}' >&2
-  exit 1
-fi
-{{end}}
-{{end -}}
-# Execute target script
-{{if .ScriptArgs -}}
-exec "{{.ScriptPath}}" {{.ScriptArgs}}
-{{else -}}
-exec "{{.ScriptPath}}"
-{{end -}}
-`
-
-type wrapperScriptData struct {
-	Env        []string
-	Hooks      []Hook
-	ScriptPath string
-	ScriptArgs string // Will be properly quoted when built
-}
-
-// GenerateWrapperScriptContent creates shell script content that sources/executes hooks and execs the target
-func (hr *HookRunner) GenerateWrapperScriptContent(hooks []Hook, scriptPath string, scriptArgs []string) (string, error) {
+// RunAfterHooks runs post or on-failure hooks once the script finished.
+// Every hook runs even if an earlier one fails, they receive the script's
+// exit code and duration in TOME_SCRIPT_EXIT_CODE and TOME_SCRIPT_DURATION_MS.
+// Hook failures are reported but never change the script's exit status.
+// It returns the hooks which ran, cached ones left out.
+func (hr *HookRunner) RunAfterHooks(phase string, hooks []Hook, scriptPath string, scriptArgs []string, result *ExecResult, env []string) []Hook {
 	if len(hooks) == 0 {
-		// No hooks, no wrapper needed
-		return "", nil
+		return nil
 	}
-
-	// Prepare template data with properly quoted args using shellescape library
-	quotedArgs := ""
-	if len(scriptArgs) > 0 {
-		quoted := make([]string, len(scriptArgs))
-		for i, arg := range scriptArgs {
-			quoted[i] = shellescape.Quote(arg)
+	environ := mergeEnv(os.Environ(), append(env, hr.afterHookEnv(phase, scriptPath, scriptArgs, result)...))
+	var ran []Hook
+	for _, hook := range hooks {
+		next, started, err := hr.RunHook(hook, environ)
+		if started {
+			ran = append(ran, hook)
 		}
-		quotedArgs = strings.Join(quoted, " ")
+		if err != nil {
+			if hook.AllowFailure {
+				fmt.Fprintf(os.Stderr, "Warning: %v, it allows failure\n", hookFailure(phase, hook, err))
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", hookFailure(phase, hook, err))
+			}
+			log.Debugw("hook failed", "phase", phase, "path", hook.Path, "error", err)
+			continue
+		}
+		environ = next
 	}
+	return ran
+}
 
-	data := wrapperScriptData{
-		Env:        hr.buildHookEnv("", scriptPath, scriptArgs),
-		Hooks:      hooks,
-		ScriptPath: scriptPath,
-		ScriptArgs: quotedArgs,
+// afterHookEnv adds the script's outcome to the hook environment
+func (hr *HookRunner) afterHookEnv(phase string, scriptPath string, scriptArgs []string, result *ExecResult) []string {
+	env := hr.hookEnv(scriptPath, scriptArgs)
+	env = append(env, fmt.Sprintf("TOME_HOOK_PHASE=%s", phase))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_EXIT_CODE=%d", result.ExitCode))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_DURATION_MS=%d", result.Duration.Milliseconds()))
+	if result.Signal != 0 {
+		env = append(env, fmt.Sprintf("TOME_SCRIPT_SIGNAL=%d", int(result.Signal)))
 	}
+	return env
+}
 
-	// Parse and execute template
-	tmpl, err := template.New("wrapper").Parse(wrapperScriptTemplate)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse wrapper template: %w", err)
-	}
+// HookDir is a .hooks.d directory, or one of its phase subdirectories
+type HookDir struct {
+	Path  string
+	Root  string
+	Scope string
+	Phase string
+}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute wrapper template: %w", err)
+// HookDirs walks every root for .hooks.d directories and their phase subdirectories
+func (hr *HookRunner) HookDirs() ([]HookDir, error) {
+	var dirs []HookDir
+	for _, root := range hr.rootDirs {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if d.Name() != ".hooks.d" {
+				return nil
+			}
+			scope, err := filepath.Rel(root, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			if scope == "." {
+				scope = ""
+			}
+			for _, phase := range hookPhases {
+				phaseDir := filepath.Join(root, scope, hookPhaseDirs[phase])
+				if info, err := os.Stat(phaseDir); err == nil && info.IsDir() {
+					dirs = append(dirs, HookDir{Path: phaseDir, Root: root, Scope: scope, Phase: phase})
+				}
+			}
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	log.Debugw("generated wrapper script content")
-	return buf.String(), nil
+	return dirs, nil
 }
 
-func (hr *HookRunner) buildHookEnv(hookPath, scriptPath string, scriptArgs []string) []string {
+// hookEnv returns the variables describing the script to its hooks
+func (hr *HookRunner) hookEnv(scriptPath string, scriptArgs []string) []string {
 	var env []string
 
-	// Add tome-cli standard vars
-	absRootDir, _ := filepath.Abs(hr.config.RootDir())
+	// Add tome-cli standard vars, TOME_ROOT being the root which provided the script
+	absRootDir, _ := filepath.Abs(NewScriptRoots(hr.config).RootFor(scriptPath))
 	env = append(env, fmt.Sprintf("TOME_ROOT=%s", absRootDir))
+	env = append(env, fmt.Sprintf("TOME_ROOTS=%s", strings.Join(hr.rootDirs, string(filepath.ListSeparator))))
 	env = append(env, fmt.Sprintf("TOME_EXECUTABLE=%s", hr.config.ExecutableName()))
 
 	// Add uppercase executable-specific vars
@@ -179,7 +377,17 @@ func (hr *HookRunner) buildHookEnv(hookPath, scriptPath string, scriptArgs []str
 	// Add script-specific vars
 	env = append(env, fmt.Sprintf("TOME_SCRIPT_PATH=%s", scriptPath))
 	env = append(env, fmt.Sprintf("TOME_SCRIPT_NAME=%s", filepath.Base(scriptPath)))
-	env = append(env, fmt.Sprintf(`TOME_SCRIPT_ARGS="%s"`, strings.Join(scriptArgs, " ")))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_ARGS=%s", strings.Join(scriptArgs, " ")))
+
+	// TOME_SCRIPT_ARGS can't tell "a b" from a and b, these keep every argument intact
+	argsJSON, _ := json.Marshal(append([]string{}, scriptArgs...))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_ARGV=%s", shellescape.QuoteCommand(scriptArgs)))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_ARGS_JSON=%s", argsJSON))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_ARG_COUNT=%d", len(scriptArgs)))
+	env = append(env, fmt.Sprintf("TOME_SCRIPT_ARG_0=%s", scriptPath))
+	for i, arg := range scriptArgs {
+		env = append(env, fmt.Sprintf("TOME_SCRIPT_ARG_%d=%s", i+1, arg))
+	}
 
 	return env
 }
@@ -0,0 +1,407 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+)
+
+// hooksCmd represents the hooks command
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "List, test and scaffold the hooks which run around scripts",
+	Long: dedent.Dedent(`
+	Hooks live in .hooks.d/ of a root or of any directory beneath it and apply
+	to the scripts in that directory and below. A hook can narrow the scripts
+	it applies to with header declarations:
+
+	  # TOME_HOOK_MATCH: deploy/**, db/migrate-*
+	  # TOME_HOOK_TAGS: prod
+
+	TOME_HOOK_MATCH takes gitignore style globs relative to the hook's directory,
+	TOME_HOOK_TAGS requires the script to list one of the tags in its TAGS: section.
+	`),
+}
+
+var hooksListCmd = &cobra.Command{
+	Use:   "list [<path-to> <script>]",
+	Short: "List every hook, or the hooks which run for a script and why",
+	Long: dedent.Dedent(`
+	Without arguments list prints every hook found in the roots.
+	Given a script it prints exactly the hooks which run for it, in order,
+	and whether hooks declaring TOME_HOOK_CACHE will reuse a cached run.
+	`),
+	ValidArgsFunction: ValidArgsFunctionForScripts,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := NewConfig()
+		hr := NewHookRunner(config)
+		out := cmd.OutOrStdout()
+		if len(args) == 0 {
+			dirs, err := hr.HookDirs()
+			if err != nil {
+				return err
+			}
+			for _, dir := range dirs {
+				hooks, err := hr.discoverHooksIn(dir.Path)
+				if err != nil {
+					return err
+				}
+				for _, hook := range hooks {
+					hook.Scope = dir.Scope
+					fmt.Fprintf(out, "%-10s %s (%s)\n", dir.Phase, hook.Path, hook.Reason())
+				}
+			}
+			return nil
+		}
+
+		script, scriptArgs, err := resolveHookScript(config, args)
+		if err != nil {
+			return err
+		}
+		environ := mergeEnv(os.Environ(), hr.hookEnv(script.path, scriptArgs))
+		count := 0
+		for _, phase := range hookPhases {
+			hooks, err := hr.DiscoverScriptHooks(phase, script)
+			if err != nil {
+				return err
+			}
+			for _, hook := range hooks {
+				fmt.Fprintf(out, "%-10s %s (%s)%s\n", phase, hook.Path, hook.Reason(), formatCacheStatus(hook.CacheStatus(environ)))
+			}
+			count += len(hooks)
+		}
+		if count == 0 {
+			fmt.Fprintf(out, "no hooks apply to %s\n", strings.Join(script.PathSegments(), " "))
+		}
+		return nil
+	},
+}
+
+var hooksRunOnly []string
+var hooksRunPhase string
+var hooksRunNoCache bool
+
+var hooksRunCmd = &cobra.Command{
+	Use:   "run [--only name] <path-to> <script> [args...]",
+	Short: "Run the hooks of a script without running the script",
+	Long: dedent.Dedent(`
+	Runs the hook chain which would run for the script, reporting the
+	status and duration of every hook. The script itself never runs.
+
+	Like the real chain, the first failing pre-run hook stops the remaining
+	hooks unless it allows failure. Timeouts and retries are enforced and
+	cached hooks are skipped unless --no-cache is given. Post-run hooks see TOME_SCRIPT_EXIT_CODE=0 and on-failure hooks
+	TOME_SCRIPT_EXIT_CODE=1.
+
+	Variables exported by sourced hooks reach the hooks after them,
+	as they would when running the script.
+	`),
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: ValidArgsFunctionForScripts,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := NewConfig()
+		if _, ok := hookPhaseDirs[hooksRunPhase]; !ok {
+			fmt.Printf("Unknown hook phase %s, expected one of %s\n", hooksRunPhase, strings.Join(hookPhases, ", "))
+			os.Exit(1)
+		}
+		script, scriptArgs, err := resolveHookScript(config, args)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		hr := NewHookRunner(config)
+		hooks, err := hr.DiscoverScriptHooks(hooksRunPhase, script)
+		if err != nil {
+			fmt.Printf("Error discovering hooks: %v\n", err)
+			os.Exit(1)
+		}
+		if len(hooksRunOnly) > 0 {
+			var selected []Hook
+			for _, hook := range hooks {
+				for _, name := range hooksRunOnly {
+					if hook.Name == name {
+						selected = append(selected, hook)
+					}
+				}
+			}
+			hooks = selected
+		}
+		if len(hooks) == 0 {
+			fmt.Printf("no %s hooks apply to %s\n", hooksRunPhase, strings.Join(script.PathSegments(), " "))
+			return
+		}
+
+		env := hr.hookEnv(script.path, scriptArgs)
+		switch hooksRunPhase {
+		case HookPhasePost:
+			env = hr.afterHookEnv(hooksRunPhase, script.path, scriptArgs, &ExecResult{})
+		case HookPhaseOnFailure:
+			env = hr.afterHookEnv(hooksRunPhase, script.path, scriptArgs, &ExecResult{ExitCode: 1})
+		}
+
+		environ := mergeEnv(os.Environ(), env)
+		hr.skipCache = hooksRunNoCache
+		failed := false
+		for _, hook := range hooks {
+			if failed && hooksRunPhase == HookPhasePre {
+				printHookStatus(os.Stdout, "skip", hook, 0, nil)
+				continue
+			}
+			start := time.Now()
+			next, ran, err := hr.RunHook(hook, environ)
+			if !ran {
+				printHookStatus(os.Stdout, "cached", hook, 0, nil)
+				continue
+			}
+			if err != nil && hook.AllowFailure {
+				printHookStatus(os.Stdout, "warn", hook, time.Since(start), err)
+				continue
+			}
+			if err != nil {
+				failed = true
+				printHookStatus(os.Stdout, "FAIL", hook, time.Since(start), err)
+				continue
+			}
+			printHookStatus(os.Stdout, "ok", hook, time.Since(start), nil)
+			environ = next
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func printHookStatus(w io.Writer, status string, hook Hook, duration time.Duration, err error) {
+	line := fmt.Sprintf("%-6s %s %s", status, filepath.Join(hook.Scope, hook.Name), duration.Round(time.Millisecond))
+	if err != nil {
+		line += fmt.Sprintf(" (%v)", err)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// resolveHookScript finds the script named by args, returning the remaining arguments
+func resolveHookScript(config *Config, args []string) (*Script, []string, error) {
+	roots := NewScriptRoots(config)
+	defer roots.Save()
+	script, scriptArgs := roots.Resolve(args)
+	if script == nil {
+		return nil, nil, fmt.Errorf("no executable file found for %s in %s", strings.Join(args, " "), strings.Join(roots.Dirs(), ", "))
+	}
+	return script, scriptArgs, nil
+}
+
+// hookOrderPrefix matches the numeric prefix which orders hooks, e.g. 00-
+var hookOrderPrefix = regexp.MustCompile(`^\d+-`)
+
+const executableHookTemplate = `#!/usr/bin/env bash
+# %s hook for scripts in this directory and below.
+# Narrow the scripts it applies to with header lines such as
+#   # TOME_HOOK_MATCH: deploy/**
+#   # TOME_HOOK_TAGS: prod
+#
+# Exiting non-zero from a pre-run hook stops the script from running.
+# Available: TOME_SCRIPT_PATH, TOME_SCRIPT_NAME, TOME_SCRIPT_ARGS, TOME_ROOT
+set -euo pipefail
+
+`
+
+const sourcedHookTemplate = `# %s hook sourced into the shell running the script.
+# Variables exported here are visible to the script and later hooks.
+# Narrow the scripts it applies to with header lines such as
+#   # TOME_HOOK_MATCH: deploy/**
+#   # TOME_HOOK_TAGS: prod
+
+`
+
+var hooksNewSource bool
+var hooksNewDir string
+var hooksNewPhase string
+
+var hooksNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "Scaffold a new hook",
+	Long: dedent.Dedent(`
+	Creates a hook in .hooks.d/ of the primary root, or of --dir relative to it.
+
+	Names without a numeric prefix get 50- so they order predictably,
+	--source adds the .source suffix and leaves the file non-executable,
+	otherwise the hook is created executable.
+
+	  tome-cli hooks new check-deps            # .hooks.d/50-check-deps
+	  tome-cli hooks new 00-login --dir aws    # aws/.hooks.d/00-login
+	  tome-cli hooks new env --source          # .hooks.d/50-env.source
+	  tome-cli hooks new notify --phase post   # .hooks.d/post.d/50-notify
+	`),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		phaseDir, ok := hookPhaseDirs[hooksNewPhase]
+		if !ok {
+			return fmt.Errorf("unknown hook phase %s, expected one of %s", hooksNewPhase, strings.Join(hookPhases, ", "))
+		}
+
+		name := args[0]
+		if strings.ContainsRune(name, filepath.Separator) {
+			return fmt.Errorf("hook name %s must not contain a path separator, use --dir", name)
+		}
+		if !hookOrderPrefix.MatchString(name) {
+			name = "50-" + name
+		}
+		sourced := hooksNewSource || strings.HasSuffix(name, ".source")
+		if sourced && !strings.HasSuffix(name, ".source") {
+			name += ".source"
+		}
+
+		hookPath := filepath.Join(NewConfig().RootDir(), hooksNewDir, phaseDir, name)
+		if _, err := os.Stat(hookPath); err == nil {
+			return fmt.Errorf("%s already exists", hookPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(hookPath), 0755); err != nil {
+			return err
+		}
+
+		content, mode := fmt.Sprintf(executableHookTemplate, hooksNewPhase), os.FileMode(0755)
+		if sourced {
+			content, mode = fmt.Sprintf(sourcedHookTemplate, hooksNewPhase), 0644
+		}
+		if err := os.WriteFile(hookPath, []byte(content), mode); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), hookPath)
+		return nil
+	},
+}
+
+// HookProblem is an issue found by hooks lint
+type HookProblem struct {
+	Path    string
+	Message string
+}
+
+// LintHooks reports hooks which are skipped or may not behave as intended
+func (hr *HookRunner) LintHooks() ([]HookProblem, error) {
+	dirs, err := hr.HookDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []HookProblem
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		settingsPath := filepath.Join(dir.Path, HookSettingsFile)
+		settings, err := loadHookSettings(dir.Path)
+		if err != nil {
+			problems = append(problems, HookProblem{settingsPath, fmt.Sprintf("unable to parse: %v", err)})
+		}
+		var names []string
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir.Path, name)); err != nil {
+				problems = append(problems, HookProblem{settingsPath, fmt.Sprintf("settings for missing hook %s", name)})
+			}
+			if err := validateCacheKey(settings[name].CacheKey); err != nil {
+				problems = append(problems, HookProblem{settingsPath, fmt.Sprintf("%s: %v", name, err)})
+			}
+			if cache := settings[name].Cache; cache != nil && *cache > 0 && strings.HasSuffix(name, ".source") {
+				problems = append(problems, HookProblem{settingsPath, fmt.Sprintf("%s: sourced hooks are never cached", name)})
+			}
+		}
+
+		for _, entry := range entries {
+			p := filepath.Join(dir.Path, entry.Name())
+			if entry.Name() == HookSettingsFile {
+				continue
+			}
+			if entry.IsDir() {
+				if dir.Phase == HookPhasePre && isHookPhaseDir(entry.Name()) {
+					continue
+				}
+				problems = append(problems, HookProblem{p, "directory is ignored, hooks must be files"})
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			sourced := strings.HasSuffix(entry.Name(), ".source")
+			if !sourced && !isExecutableByOwner(info.Mode()) {
+				problems = append(problems, HookProblem{p, "not executable and has no .source suffix, it is skipped (chmod +x or rename to .source)"})
+			}
+			if !hookOrderPrefix.MatchString(entry.Name()) {
+				problems = append(problems, HookProblem{p, "name has no numeric prefix such as 00-, its order is unclear"})
+			}
+			header, err := parseHookHeader(p)
+			if err != nil {
+				for _, message := range strings.Split(err.Error(), "\n") {
+					problems = append(problems, HookProblem{p, message})
+				}
+			}
+			if sourced && header.Cache > 0 {
+				problems = append(problems, HookProblem{p, "sourced hooks are never cached, TOME_HOOK_CACHE is ignored"})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// isHookPhaseDir reports whether name is a phase subdirectory of .hooks.d
+func isHookPhaseDir(name string) bool {
+	for _, phase := range hookPhases {
+		if phase != HookPhasePre && filepath.Base(hookPhaseDirs[phase]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+var hooksLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Report hooks which are skipped or misnamed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		problems, err := NewHookRunner(NewConfig()).LintHooks()
+		if err != nil {
+			fmt.Printf("Error linting hooks: %v\n", err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", problem.Path, problem.Message)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	hooksRunCmd.Flags().StringArrayVar(&hooksRunOnly, "only", nil, "Only run the named hook (repeatable)")
+	hooksRunCmd.Flags().BoolVar(&hooksRunNoCache, "no-cache", false, "Run hooks even when a cached run could be reused")
+	hooksRunCmd.Flags().StringVar(&hooksRunPhase, "phase", HookPhasePre, "Hook phase to run: pre, post or on-failure")
+	hooksNewCmd.Flags().BoolVar(&hooksNewSource, "source", false, "Create a sourced hook which can modify the script's environment")
+	hooksNewCmd.Flags().StringVar(&hooksNewDir, "dir", "", "Directory relative to the root whose scripts the hook applies to")
+	hooksNewCmd.Flags().StringVar(&hooksNewPhase, "phase", HookPhasePre, "Hook phase: pre, post or on-failure")
+	hooksCmd.AddCommand(hooksListCmd)
+	hooksCmd.AddCommand(hooksRunCmd)
+	hooksCmd.AddCommand(hooksNewCmd)
+	hooksCmd.AddCommand(hooksLintCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
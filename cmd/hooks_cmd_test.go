@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestHookDirs tests finding every .hooks.d directory across roots
+func TestHookDirs(t *testing.T) {
+	roots := setupTestRoots(t, 2)
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-a"), "#!/bin/bash\n")
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "post.d", "00-b"), "#!/bin/bash\n")
+	writeTestScript(t, filepath.Join(roots[1], "aws", ".hooks.d", "00-c"), "#!/bin/bash\n")
+
+	dirs, err := NewHookRunner(NewConfig()).HookDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, dir := range dirs {
+		got = append(got, dir.Phase+" "+filepath.Join(dir.Scope, filepath.Base(dir.Path)))
+	}
+	expected := []string{"pre .hooks.d", "post post.d", "pre aws/.hooks.d"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// TestLintHooks tests reporting hooks which DiscoverHooks skips
+func TestLintHooks(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	hooksDir := filepath.Join(roots[0], ".hooks.d")
+	writeTestScript(t, filepath.Join(hooksDir, "00-ok"), "#!/bin/bash\n")
+	writeTestScript(t, filepath.Join(hooksDir, "post.d", "00-ok"), "#!/bin/bash\n")
+	if err := os.WriteFile(filepath.Join(hooksDir, "10-env.source"), []byte("export A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(hooksDir, "20-forgot-chmod"), []byte("#!/bin/bash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestScript(t, filepath.Join(hooksDir, "unordered"), "#!/bin/bash\n")
+	if err := os.Mkdir(filepath.Join(hooksDir, "misc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := NewHookRunner(NewConfig()).LintHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, problem := range problems {
+		got[filepath.Base(problem.Path)] = problem.Message
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 problems, got %v", got)
+	}
+	for name, expected := range map[string]string{"20-forgot-chmod": "not executable", "unordered": "numeric prefix", "misc": "directory is ignored"} {
+		if !strings.Contains(got[name], expected) {
+			t.Errorf("%s: expected problem mentioning %q, got %q", name, expected, got[name])
+		}
+	}
+}
+
+// TestRunHook tests running a single hook with the script's environment
+func TestRunHook(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	output := filepath.Join(t.TempDir(), "out")
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-record"), "#!/bin/bash\necho \"$TOME_SCRIPT_NAME\" > "+output+"\n")
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "10-fail"), "#!/bin/bash\nexit 3\n")
+	script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+
+	hr := NewHookRunner(NewConfig())
+	hooks, err := hr.DiscoverScriptHooks(HookPhasePre, script)
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("expected 2 hooks, got %v (%v)", hooks, err)
+	}
+	environ := mergeEnv(os.Environ(), hr.hookEnv(script.path, nil))
+
+	if _, _, err := hr.RunHook(hooks[0], environ); err != nil {
+		t.Errorf("expected hook to succeed: %v", err)
+	}
+	if body, _ := os.ReadFile(output); string(body) != "deploy\n" {
+		t.Errorf("expected hook to see TOME_SCRIPT_NAME, got %q", body)
+	}
+	if _, _, err := hr.RunHook(hooks[1], environ); err == nil {
+		t.Error("expected failing hook to return an error")
+	}
+}
@@ -8,16 +8,32 @@ import (
 	"testing"
 )
 
-// executeWrapperContent executes wrapper script content using bash -c
-func executeWrapperContent(content string) ([]byte, error) {
-	cmd := exec.Command("bash", "-c", content)
+// runWithPreHooks runs the pre-run hooks natively and then the script with
+// the environment they leave behind, as exec does
+func runWithPreHooks(hr *HookRunner, hooks []Hook, scriptPath string, scriptArgs []string) ([]byte, error) {
+	env, _, err := hr.RunPreHooks(hooks, scriptPath, scriptArgs, nil)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(scriptPath, scriptArgs...)
+	cmd.Env = mergeEnv(os.Environ(), env)
 	return cmd.CombinedOutput()
 }
 
-// executeWrapperContentWithSh executes wrapper script content using sh -c
-func executeWrapperContentWithSh(content string) ([]byte, error) {
-	cmd := exec.Command("sh", "-c", content)
-	return cmd.CombinedOutput()
+// withoutBash leaves only sh and env on PATH, so sourced hooks fall back to sh
+func withoutBash(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"sh", "env"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			t.Skipf("%s not found: %v", name, err)
+		}
+		if err := os.Symlink(path, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
 }
 
 // TestHookExecution tests end-to-end hook execution
@@ -58,15 +74,10 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
+		// Run the hooks and the script
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
-		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify hook executed
@@ -120,15 +131,10 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
+		// Run the hooks and the script
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify script received the environment variable
@@ -182,20 +188,15 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute wrapper - should fail
-		output, err := executeWrapperContent(wrapperContent)
+		// Run the hooks - should fail
+		_, err = runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err == nil {
-			t.Fatal("Expected wrapper to fail due to hook failure")
+			t.Fatal("Expected execution to fail due to hook failure")
 		}
 
 		// Verify error message mentions hook
-		if !strings.Contains(string(output), "pre-hook failed") {
-			t.Errorf("Error output should mention pre-hook failure: %s", output)
+		if !strings.Contains(err.Error(), "pre-hook failed") {
+			t.Errorf("Error should mention pre-hook failure: %v", err)
 		}
 
 		// Verify script did not execute
@@ -253,15 +254,10 @@ exit 0
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		wrapperContent, err := hr.GenerateWrapperScriptContent(discoveredHooks, scriptPath, []string{})
+		// Run the hooks and the script
+		output, err := runWithPreHooks(hr, discoveredHooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatal(err)
-		}
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
-		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify execution order
@@ -329,15 +325,10 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
+		// Run the hooks and the script
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify output
@@ -396,15 +387,10 @@ exit 0
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{"arg1", "arg2"})
+		// Run the hooks and the script
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{"arg1", "arg2"})
 		if err != nil {
-			t.Fatal(err)
-		}
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
-		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify environment variables
@@ -466,15 +452,10 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute wrapper - should fail
-		_, err = executeWrapperContent(wrapperContent)
+		// Run the hooks - should fail
+		_, err = runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err == nil {
-			t.Fatal("Expected wrapper to fail due to sourced hook failure")
+			t.Fatal("Expected execution to fail due to sourced hook failure")
 		}
 
 		// Verify script did not execute
@@ -484,9 +465,9 @@ exit 0
 	})
 }
 
-// TestShellCompatibility tests that wrapper scripts work with sh, not just bash
+// TestShellCompatibility tests that hooks work with sh, not just bash
 func TestShellCompatibility(t *testing.T) {
-	t.Run("wrapper script executes with sh", func(t *testing.T) {
+	t.Run("hooks run without bash", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		hooksDir := filepath.Join(tmpDir, ".hooks.d")
 		if err := os.Mkdir(hooksDir, 0755); err != nil {
@@ -515,23 +496,17 @@ exit 0
 			t.Fatal(err)
 		}
 
-		// Generate wrapper and execute with sh
+		// Only sh is left on PATH
+		withoutBash(t)
 		config := setupTestConfig(t, tmpDir, "tome-cli")
 		hr := NewHookRunner(config)
 		hooks, err := hr.DiscoverHooks()
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute with sh explicitly
-		output, err := executeWrapperContentWithSh(wrapperContent)
-		if err != nil {
-			t.Fatalf("Wrapper execution with sh failed: %v, output: %s", err, output)
+			t.Fatalf("Execution with sh failed: %v, output: %s", err, output)
 		}
 
 		// Verify hook executed
@@ -573,7 +548,8 @@ exit 0
 			t.Fatal(err)
 		}
 
-		// Generate and execute with sh
+		// Only sh is left on PATH
+		withoutBash(t)
 		config := setupTestConfig(t, tmpDir, "tome-cli")
 		hr := NewHookRunner(config)
 		hooks, err := hr.DiscoverHooks()
@@ -581,14 +557,9 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatal(err)
-		}
-
-		output, err := executeWrapperContentWithSh(wrapperContent)
-		if err != nil {
-			t.Fatalf("Wrapper execution with sh failed: %v, output: %s", err, output)
+			t.Fatalf("Execution with sh failed: %v, output: %s", err, output)
 		}
 
 		// Verify variable was set
@@ -602,40 +573,51 @@ exit 0
 		}
 	})
 
-	t.Run("wrapper uses POSIX-compliant syntax", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		// Create minimal hooks with tmpDir-based path
-		hookPath := filepath.Join(tmpDir, "hook1")
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "00-hook1",
-				Sourced: false,
-			},
+	t.Run("paths with spaces", func(t *testing.T) {
+		tmpDir := filepath.Join(t.TempDir(), "my scripts")
+		hooksDir := filepath.Join(tmpDir, ".hooks.d")
+		if err := os.MkdirAll(hooksDir, 0755); err != nil {
+			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{"arg1", "arg2"})
-		if err != nil {
+		outputFile := filepath.Join(tmpDir, "output file.txt")
+		hookContent := `#!/bin/sh
+echo "hook" >> "` + outputFile + `"
+`
+		if err := os.WriteFile(filepath.Join(hooksDir, "00 my hook"), []byte(hookContent), 0755); err != nil {
+			t.Fatal(err)
+		}
+		sourceContent := `echo "source" >> "` + outputFile + `"
+`
+		if err := os.WriteFile(filepath.Join(hooksDir, "05 my env.source"), []byte(sourceContent), 0644); err != nil {
 			t.Fatal(err)
 		}
 
-		// Verify wrapper doesn't use bash-specific features
-		// Check it uses 'set -e' which is POSIX
-		if !strings.Contains(wrapperContent, "set -e") {
-			t.Error("Wrapper should use POSIX 'set -e'")
+		scriptContent := `#!/bin/sh
+echo "script $1" >> "` + outputFile + `"
+`
+		scriptPath := filepath.Join(tmpDir, "my script")
+		if err := os.WriteFile(scriptPath, []byte(scriptContent), 0755); err != nil {
+			t.Fatal(err)
 		}
 
-		// Verify it doesn't use bash-specific features like [[
-		if strings.Contains(wrapperContent, "[[") {
-			t.Error("Wrapper should not use bash-specific [[ syntax")
+		config := setupTestConfig(t, tmpDir, "tome-cli")
+		hr := NewHookRunner(config)
+		hooks, err := hr.DiscoverHooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{"an arg"})
+		if err != nil {
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
-		// Verify exec command is POSIX
-		if !strings.Contains(wrapperContent, "exec /fake/script") {
-			t.Error("Wrapper should use POSIX exec")
+		content, err := os.ReadFile(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "hook\nsource\nscript an arg\n"; string(content) != expected {
+			t.Errorf("expected %q, got %q", expected, content)
 		}
 	})
 }
@@ -692,17 +674,11 @@ exit 0
 			t.Fatal(err)
 		}
 
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{})
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		// Execute with REQUIRED_VAR set
-		cmd := exec.Command("bash", "-c", wrapperContent)
-		cmd.Env = append(os.Environ(), "REQUIRED_VAR=test_value")
-		output, err := cmd.CombinedOutput()
+		// Run with REQUIRED_VAR set
+		t.Setenv("REQUIRED_VAR", "test_value")
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{})
 		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify script received all environment variables
@@ -759,15 +735,9 @@ exit 0
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		wrapperContent, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, []string{"--env", "production"})
-		if err != nil {
-			t.Fatal(err)
-		}
-		// Execute wrapper
-		output, err := executeWrapperContent(wrapperContent)
+		output, err := runWithPreHooks(hr, hooks, scriptPath, []string{"--env", "production"})
 		if err != nil {
-			t.Fatalf("Wrapper execution failed: %v, output: %s", err, output)
+			t.Fatalf("Execution failed: %v, output: %s", err, output)
 		}
 
 		// Verify audit log was created
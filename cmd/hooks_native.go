@@ -0,0 +1,239 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sourcedHookScript sources the hook given as $1 and writes the resulting
+// environment NUL separated to fd 3, so it can be carried over to the script
+const sourcedHookScript = `# POSIX shells such as dash only provide '.'
+command -v source >/dev/null 2>&1 || source() { . "$@"; }
+tome_hook=$1
+shift
+# Background jobs of the hook must not hold the capture open
+source "$tome_hook" 3>&- || exit $?
+exec env -0 >&3
+`
+
+// shellEnvVars are maintained by the shell itself and never carried over from sourced hooks
+var shellEnvVars = map[string]bool{"_": true, "SHLVL": true, "PWD": true, "OLDPWD": true}
+
+// RunPreHooks runs pre-run hooks without a wrapper shell, stopping at the first
+// failure of a hook which does not allow failure.
+// It returns env extended with the hook variables and whatever sourced hooks exported,
+// variables they unset are removed from the process environment, and the hooks which
+// ran, failed ones included and cached ones left out.
+func (hr *HookRunner) RunPreHooks(hooks []Hook, scriptPath string, scriptArgs []string, env []string) ([]string, []Hook, error) {
+	env = append(env, hr.hookEnv(scriptPath, scriptArgs)...)
+	environ := mergeEnv(os.Environ(), env)
+	var ran []Hook
+	for _, hook := range hooks {
+		next, started, err := hr.RunHook(hook, environ)
+		if started {
+			ran = append(ran, hook)
+		}
+		if err != nil {
+			failure := hookFailure(HookPhasePre, hook, err)
+			if hook.AllowFailure {
+				fmt.Fprintf(os.Stderr, "Warning: %v, continuing as it allows failure\n", failure)
+				continue
+			}
+			return nil, ran, failure
+		}
+
+		set, unset := diffEnv(environ, next)
+		env = append(env, set...)
+		for _, key := range unset {
+			os.Unsetenv(key)
+			env = removeEnv(env, key)
+		}
+		environ = next
+	}
+	return env, ran, nil
+}
+
+// hookFailure describes a failed hook
+func hookFailure(phase string, hook Hook, err error) error {
+	separator := " "
+	if phase == HookPhasePre {
+		separator = "-"
+	}
+	switch {
+	case errors.Is(err, errHookTimedOut):
+		return fmt.Errorf("%s%shook failed: %s (%v)", phase, separator, hook.Name, err)
+	case hook.Sourced:
+		return fmt.Errorf("%s%shook failed: %s (sourcing failed)", phase, separator, hook.Name)
+	default:
+		return fmt.Errorf("%s%shook failed: %s", phase, separator, hook.Name)
+	}
+}
+
+// RunHook runs a single hook with environ as its environment and returns the
+// environment for the hooks after it. Executable hooks run directly, only
+// sourced hooks need a shell and may change the returned environment.
+// A failing hook is attempted again up to its retries, each attempt bounded by its timeout.
+// Hooks declaring TOME_HOOK_CACHE are skipped while a successful run is cached,
+// ran reports whether the hook was started.
+func (hr *HookRunner) RunHook(hook Hook, environ []string) (next []string, ran bool, err error) {
+	cache := NewHookCache(hook, environ)
+	if !hr.skipCache && cache.Fresh() {
+		log.Debugw("reusing cached hook run", "path", hook.Path)
+		return environ, false, nil
+	}
+
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			log.Debugw("retrying hook", "path", hook.Path, "attempt", attempt+1, "error", err)
+		}
+		if next, err = hr.runHookOnce(hook, environ); err == nil {
+			cache.Put()
+			return next, true, nil
+		}
+	}
+	return nil, true, err
+}
+
+// runHookOnce makes a single attempt at running hook
+func (hr *HookRunner) runHookOnce(hook Hook, environ []string) ([]string, error) {
+	log.Debugw("running hook", "path", hook.Path, "sourced", hook.Sourced, "timeout", hook.Timeout)
+	ctx := context.Background()
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+
+	var next []string
+	var err error
+	if hook.Sourced {
+		next, err = hr.runSourcedHook(ctx, hook, environ)
+	} else {
+		next, err = environ, hr.runExecutableHook(ctx, hook, environ)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w after %s", errHookTimedOut, hook.Timeout)
+	}
+	return next, err
+}
+
+// runExecutableHook runs hook directly, without a shell
+func (hr *HookRunner) runExecutableHook(ctx context.Context, hook Hook, environ []string) error {
+	cmd := exec.CommandContext(ctx, hook.Path)
+	cmd.Env = environ
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runSourcedHook sources hook in a shell and captures the environment it leaves behind
+func (hr *HookRunner) runSourcedHook(ctx context.Context, hook Hook, environ []string) ([]string, error) {
+	shellPath, err := findShell()
+	if err != nil {
+		return nil, fmt.Errorf("sourced hook %s needs a shell: %w", hook.Name, err)
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	cmd := exec.CommandContext(ctx, shellPath, "-c", sourcedHookScript, "tome-hook", hook.Path)
+	cmd.Env = environ
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{writer}
+	if err := cmd.Start(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	// Only the child may hold the write end, otherwise reading never ends
+	writer.Close()
+
+	var captured bytes.Buffer
+	_, copyErr := io.Copy(&captured, reader)
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return nil, copyErr
+	}
+
+	var next []string
+	for _, kv := range strings.Split(captured.String(), "\x00") {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || shellEnvVars[key] {
+			continue
+		}
+		next = append(next, kv)
+	}
+	// Keep what the shell maintains as it was before the hook
+	for _, kv := range environ {
+		if key, _, _ := strings.Cut(kv, "="); shellEnvVars[key] {
+			next = append(next, kv)
+		}
+	}
+	return next, nil
+}
+
+// mergeEnv returns base with overrides applied, the last value of a variable wins
+func mergeEnv(base []string, overrides []string) []string {
+	index := map[string]int{}
+	var merged []string
+	for _, kv := range append(append([]string{}, base...), overrides...) {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = kv
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	return merged
+}
+
+// diffEnv returns the variables set or changed in after and the names of those removed
+func diffEnv(before []string, after []string) (set []string, unset []string) {
+	previous := map[string]string{}
+	for _, kv := range before {
+		key, value, _ := strings.Cut(kv, "=")
+		previous[key] = value
+	}
+	current := map[string]bool{}
+	for _, kv := range after {
+		key, value, _ := strings.Cut(kv, "=")
+		current[key] = true
+		if old, ok := previous[key]; !ok || old != value {
+			set = append(set, kv)
+		}
+	}
+	for _, kv := range before {
+		key, _, _ := strings.Cut(kv, "=")
+		if !current[key] {
+			unset = append(unset, key)
+		}
+	}
+	return set, unset
+}
+
+// removeEnv drops every entry of key from env
+func removeEnv(env []string, key string) []string {
+	kept := env[:0]
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k != key {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunPreHooks tests running hooks without a wrapper shell
+func TestRunPreHooks(t *testing.T) {
+	t.Run("sourced exports reach the script env", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		output := filepath.Join(t.TempDir(), "out")
+		hooksDir := filepath.Join(roots[0], ".hooks.d")
+		if err := os.MkdirAll(hooksDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(hooksDir, "00-env.source"), []byte("export FROM_HOOK=\"a b\"\nunset TOME_TEST_UNSET\nexport TOME_ROOT=/changed\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		writeTestScript(t, filepath.Join(hooksDir, "10-check"), "#!/bin/sh\necho \"$FROM_HOOK|$TOME_SCRIPT_ARGS|${TOME_TEST_UNSET-unset}\" > "+output+"\n")
+		t.Setenv("TOME_TEST_UNSET", "present")
+
+		script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+		hr := NewHookRunner(NewConfig())
+		hooks, err := hr.DiscoverScriptHooks(HookPhasePre, script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env, _, err := hr.RunPreHooks(hooks, script.path, []string{"x", "y"}, []string{"TOME_ROOT=" + roots[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := os.ReadFile(output); string(body) != "a b|x y|unset\n" {
+			t.Errorf("expected the executable hook to see sourced exports, got %q", body)
+		}
+		merged := mergeEnv(os.Environ(), env)
+		for _, expected := range []string{"FROM_HOOK=a b", "TOME_ROOT=/changed", "TOME_SCRIPT_NAME=deploy"} {
+			if !slices.Contains(merged, expected) {
+				t.Errorf("expected %s in the script env", expected)
+			}
+		}
+		if _, ok := os.LookupEnv("TOME_TEST_UNSET"); ok {
+			t.Error("expected unset variable to be removed")
+		}
+		for _, kv := range merged {
+			if strings.HasPrefix(kv, "SHLVL=") && !slices.Contains(os.Environ(), kv) {
+				t.Errorf("expected shell variables not to leak, got %s", kv)
+			}
+		}
+	})
+
+	t.Run("arguments reach hooks intact", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		output := filepath.Join(t.TempDir(), "out")
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-args"), "#!/bin/sh\neval \"set -- $TOME_SCRIPT_ARGV\"\nprintf '%s|%s|%s|%s' \"$#\" \"$2\" \"$TOME_SCRIPT_ARG_COUNT\" \"$TOME_SCRIPT_ARG_2\" > "+output+"\n")
+
+		script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+		hr := NewHookRunner(NewConfig())
+		hooks, _ := hr.DiscoverScriptHooks(HookPhasePre, script)
+		args := []string{"a b", "it's \"$HOME\"\nnext"}
+		env, _, err := hr.RunPreHooks(hooks, script.path, args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "2|" + args[1] + "|2|" + args[1]
+		if body, _ := os.ReadFile(output); string(body) != expected {
+			t.Errorf("expected %q, got %q", expected, body)
+		}
+		for _, kv := range env {
+			if value, ok := strings.CutPrefix(kv, "TOME_SCRIPT_ARGS_JSON="); ok {
+				var decoded []string
+				if err := json.Unmarshal([]byte(value), &decoded); err != nil || !reflect.DeepEqual(decoded, args) {
+					t.Errorf("expected TOME_SCRIPT_ARGS_JSON to decode to %q, got %q (%v)", args, decoded, err)
+				}
+			}
+		}
+		if !slices.Contains(env, "TOME_SCRIPT_ARG_0="+script.path) {
+			t.Error("expected TOME_SCRIPT_ARG_0 to be the script path")
+		}
+	})
+
+	t.Run("failure stops the chain", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		output := filepath.Join(t.TempDir(), "out")
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-fail"), "#!/bin/sh\nexit 4\n")
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "10-never"), "#!/bin/sh\ntouch "+output+"\n")
+
+		script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+		hr := NewHookRunner(NewConfig())
+		hooks, _ := hr.DiscoverScriptHooks(HookPhasePre, script)
+		_, ran, err := hr.RunPreHooks(hooks, script.path, nil, nil)
+		if err == nil || err.Error() != "pre-hook failed: 00-fail" {
+			t.Errorf("expected pre-hook failure, got %v", err)
+		}
+		if len(ran) != 1 || ran[0].Name != "00-fail" {
+			t.Errorf("expected only the failed hook to be reported, got %+v", ran)
+		}
+		if _, err := os.Stat(output); err == nil {
+			t.Error("expected later hooks not to run")
+		}
+	})
+}
+
+// TestHookExecutionSettings tests enforcing timeouts, retries and allowed failures
+func TestHookExecutionSettings(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	counter := filepath.Join(t.TempDir(), "count")
+	hooksDir := filepath.Join(roots[0], ".hooks.d")
+	writeTestScript(t, filepath.Join(hooksDir, "00-hang"), "#!/bin/sh\n# TOME_HOOK_TIMEOUT: 200ms\n# TOME_HOOK_ALLOW_FAILURE\nsleep 10\n")
+	writeTestScript(t, filepath.Join(hooksDir, "10-flaky"), "#!/bin/sh\n# TOME_HOOK_RETRIES: 2\necho x >> "+counter+"\n[ $(wc -l < "+counter+") -ge 3 ]\n")
+	writeTestScript(t, filepath.Join(hooksDir, "20-sourced-hang.source"), "# TOME_HOOK_TIMEOUT: 200ms\nsleep 10\n")
+
+	script := NewScript(filepath.Join(roots[0], "deploy"), roots[0])
+	hr := NewHookRunner(NewConfig())
+	hooks, err := hr.DiscoverScriptHooks(HookPhasePre, script)
+	if err != nil || len(hooks) != 3 {
+		t.Fatalf("expected 3 hooks, got %+v (%v)", hooks, err)
+	}
+
+	start := time.Now()
+	_, _, err = hr.RunPreHooks(hooks, script.path, nil, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected hung hooks to be killed, took %s", elapsed)
+	}
+	if err == nil || err.Error() != "pre-hook failed: 20-sourced-hang.source (timed out after 200ms)" {
+		t.Errorf("expected the sourced hook to time out, got %v", err)
+	}
+	if body, _ := os.ReadFile(counter); strings.Count(string(body), "x") != 3 {
+		t.Errorf("expected the flaky hook to run 3 times, got %q", body)
+	}
+}
+
+// TestMergeEnv tests overriding environment entries
+func TestMergeEnv(t *testing.T) {
+	merged := mergeEnv([]string{"A=1", "B=2"}, []string{"B=3", "C=4", "A=5"})
+	if !reflect.DeepEqual(merged, []string{"A=5", "B=3", "C=4"}) {
+		t.Errorf("unexpected merge: %v", merged)
+	}
+
+	set, unset := diffEnv([]string{"A=1", "B=2", "C=3"}, []string{"A=1", "B=4", "D=5"})
+	if !reflect.DeepEqual(set, []string{"B=4", "D=5"}) || !reflect.DeepEqual(unset, []string{"C"}) {
+		t.Errorf("unexpected diff: set %v unset %v", set, unset)
+	}
+}
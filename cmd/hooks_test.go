@@ -1,15 +1,19 @@
 package cmd
 
 import (
+	"encoding/json"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/spf13/viper"
 )
 
 // setupTestConfig sets up viper config for testing
-func setupTestConfig(t *testing.T, rootDir, executableName string) *Config {
+func setupTestConfig(t *testing.T, rootDir, execName string) *Config {
 	t.Helper()
 
 	// Initialize logger if not already done
@@ -20,10 +24,12 @@ func setupTestConfig(t *testing.T, rootDir, executableName string) *Config {
 	// Save current values
 	oldRoot := viper.Get("root")
 	oldExec := viper.Get("executable")
+	oldExecutableName := executableName
 
-	// Set test values
+	// Set test values, the executable name also prefixes env vars such as TOME_CLI_ROOT
 	viper.Set("root", rootDir)
-	viper.Set("executable", executableName)
+	viper.Set("executable", execName)
+	executableName = execName
 
 	// Restore on cleanup
 	t.Cleanup(func() {
@@ -33,6 +39,7 @@ func setupTestConfig(t *testing.T, rootDir, executableName string) *Config {
 		if oldExec != nil {
 			viper.Set("executable", oldExec)
 		}
+		executableName = oldExecutableName
 	})
 
 	return NewConfig()
@@ -267,274 +274,97 @@ func TestHookDiscovery(t *testing.T) {
 			t.Errorf("Third hook should be executable '10-another'")
 		}
 	})
-}
-
-// TestGenerateWrapperScriptContent tests the wrapper script generation
-func TestGenerateWrapperScriptContent(t *testing.T) {
-	t.Run("no hooks returns empty content", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		content, err := hr.GenerateWrapperScriptContent([]Hook{}, "/fake/script", []string{})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content != "" {
-			t.Errorf("GenerateWrapperScriptContent() expected empty content for no hooks, got '%s'", content)
-		}
-	})
-
-	t.Run("generates wrapper with executable hook", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		hookPath := filepath.Join(tmpDir, "00-test")
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "00-test",
-				Sourced: false,
-			},
-		}
-
-		content, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{"arg1", "arg2"})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
-		}
-
-		// Check for hook execution (now quoted)
-		if !contains(content, `"`+hookPath+`"`) {
-			t.Error("Wrapper script missing hook execution")
-		}
-		// Check for target script exec (shellescape only quotes when necessary)
-		if !contains(content, `exec "/fake/script" arg1 arg2`) {
-			t.Error("Wrapper script missing target script exec")
-		}
-	})
-
-	t.Run("generates wrapper with sourced hook", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		hookPath := filepath.Join(tmpDir, "05-env.source")
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "05-env.source",
-				Sourced: true,
-			},
-		}
-
-		content, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
-		}
-
-		contentStr := content
-		// Check for source command (now quoted)
-		if !contains(contentStr, `source "`+hookPath+`"`) {
-			t.Error("Wrapper script missing source command for .source hook")
-		}
-	})
-
-	t.Run("wrapper includes environment variables", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		hookPath := filepath.Join(tmpDir, "00-test")
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "00-test",
-				Sourced: false,
-			},
-		}
-
-		content, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{"arg1"})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
-		}
-
-		contentStr := content
-		// Check for environment variables
-		if !contains(contentStr, "TOME_ROOT=") {
-			t.Error("Wrapper script missing TOME_ROOT")
-		}
-		if !contains(contentStr, "TOME_EXECUTABLE=") {
-			t.Error("Wrapper script missing TOME_EXECUTABLE")
-		}
-		if !contains(contentStr, "TOME_SCRIPT_PATH=") {
-			t.Error("Wrapper script missing TOME_SCRIPT_PATH")
-		}
-		if !contains(contentStr, "TOME_SCRIPT_NAME=") {
-			t.Error("Wrapper script missing TOME_SCRIPT_NAME")
-		}
-		if !contains(contentStr, "TOME_SCRIPT_ARGS=") {
-			t.Error("Wrapper script missing TOME_SCRIPT_ARGS")
-		}
-	})
-
-	t.Run("wrapper is executable", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		hookPath := filepath.Join(tmpDir, "00-test")
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "00-test",
-				Sourced: false,
-			},
-		}
-
-		content, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
-		}
 
-		// Verify content is valid shell script with expected structure
-		if !contains(content, "set -e") {
-			t.Error("Wrapper script missing 'set -e' directive")
-		}
-		if !contains(content, `exec "/fake/script"`) {
-			t.Error("Wrapper script missing exec command for target script")
+	t.Run("scoped hooks run root to leaf", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "50-root"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", ".hooks.d", "00-login"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", "ec2", ".hooks.d", "00-region"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", "ec2", ".hooks.d", "post.d", "00-report"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "gcp", ".hooks.d", "00-login"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", "ec2", "list"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "hello"), "#!/bin/bash\n")
+
+		hr := NewHookRunner(NewConfig())
+		names := func(script *Script, phase string) []string {
+			hooks, err := hr.DiscoverScriptHooks(phase, script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, hook := range hooks {
+				names = append(names, filepath.Join(hook.Scope, hook.Name))
+			}
+			return names
 		}
-	})
-
-	t.Run("wrapper handles multiple hooks in order", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
 
-		hook1Path := filepath.Join(tmpDir, "00-first")
-		hook2Path := filepath.Join(tmpDir, "05-env.source")
-		hook3Path := filepath.Join(tmpDir, "10-second")
-
-		hooks := []Hook{
-			{
-				Path:    hook1Path,
-				Name:    "00-first",
-				Sourced: false,
-			},
-			{
-				Path:    hook2Path,
-				Name:    "05-env.source",
-				Sourced: true,
-			},
-			{
-				Path:    hook3Path,
-				Name:    "10-second",
-				Sourced: false,
-			},
-		}
-
-		content, err := hr.GenerateWrapperScriptContent(hooks, "/fake/script", []string{})
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
+		list := NewScript(filepath.Join(roots[0], "aws", "ec2", "list"), roots[0])
+		if got := names(list, HookPhasePre); !reflect.DeepEqual(got, []string{"50-root", "aws/00-login", "aws/ec2/00-region"}) {
+			t.Errorf("unexpected pre hooks for aws/ec2/list: %v", got)
 		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
+		if got := names(list, HookPhasePost); !reflect.DeepEqual(got, []string{"aws/ec2/00-report"}) {
+			t.Errorf("unexpected post hooks for aws/ec2/list: %v", got)
 		}
 
-		contentStr := content
-		// Verify all hooks are present (now with quotes)
-		if !contains(contentStr, `"`+hook1Path+`"`) {
-			t.Error("Wrapper script missing first hook")
+		hello := NewScript(filepath.Join(roots[0], "hello"), roots[0])
+		if got := names(hello, HookPhasePre); !reflect.DeepEqual(got, []string{"50-root"}) {
+			t.Errorf("expected only root hooks for hello, got %v", got)
 		}
-		if !contains(contentStr, `source "`+hook2Path+`"`) {
-			t.Error("Wrapper script missing sourced hook")
-		}
-		if !contains(contentStr, `"`+hook3Path+`"`) {
-			t.Error("Wrapper script missing second hook")
-		}
-
-		// Verify order (crude check - first hook should appear before second)
-		firstIdx := indexOf(contentStr, "00-first")
-		secondIdx := indexOf(contentStr, "05-env.source")
-		thirdIdx := indexOf(contentStr, "10-second")
 
-		if firstIdx == -1 || secondIdx == -1 || thirdIdx == -1 {
-			t.Error("Not all hooks found in wrapper")
-		}
-		if firstIdx > secondIdx || secondIdx > thirdIdx {
-			t.Error("Hooks not in correct order")
+		hooks, _ := hr.DiscoverScriptHooks(HookPhasePre, list)
+		if reason := hooks[1].Reason(); reason != "scoped to aws/" {
+			t.Errorf("unexpected reason %q", reason)
 		}
 	})
 
-	t.Run("wrapper handles paths with spaces", func(t *testing.T) {
-		tmpDir := t.TempDir()
-		config := setupTestConfig(t, tmpDir, "tome-cli")
-		hr := NewHookRunner(config)
-
-		// Create hook and script paths with spaces
-		hookPath := filepath.Join(tmpDir, "hook with spaces")
-		scriptPath := filepath.Join(tmpDir, "script with spaces.sh")
-
-		hooks := []Hook{
-			{
-				Path:    hookPath,
-				Name:    "hook with spaces",
-				Sourced: false,
-			},
-		}
-
-		args := []string{"arg with spaces", "normal-arg", "another arg"}
-		content, err := hr.GenerateWrapperScriptContent(hooks, scriptPath, args)
-		if err != nil {
-			t.Errorf("GenerateWrapperScriptContent() returned error: %v", err)
-		}
-		if content == "" {
-			t.Fatal("GenerateWrapperScriptContent() returned empty content")
-		}
-
-		// Verify hook path is quoted
-		if !contains(content, `"`+hookPath+`"`) {
-			t.Error("Wrapper script missing quoted hook path")
-		}
-
-		// Verify script path is quoted
-		if !contains(content, `"`+scriptPath+`"`) {
-			t.Error("Wrapper script missing quoted script path")
+	t.Run("hooks select scripts by glob and tag", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-deploy"), "#!/bin/bash\n# TOME_HOOK_MATCH: deploy/**, db/migrate-*\n")
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "10-prod"), "#!/bin/bash\n# TOME_HOOK_TAGS: prod\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", ".hooks.d", "20-ec2"), "#!/bin/bash\n# TOME_HOOK_MATCH: ec2/*\n")
+		writeTestScript(t, filepath.Join(roots[0], "deploy", "web"), "#!/bin/bash\n# USAGE: $0\n# TAGS: prod, web\n")
+		writeTestScript(t, filepath.Join(roots[0], "db", "migrate-up"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "db", "dump"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", "ec2", "list"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[0], "aws", "s3"), "#!/bin/bash\n")
+
+		hr := NewHookRunner(NewConfig())
+		tests := map[string][]string{
+			"deploy/web":    {"00-deploy", "10-prod"},
+			"db/migrate-up": {"00-deploy"},
+			"db/dump":       nil,
+			"aws/ec2/list":  {"20-ec2"},
+			"aws/s3":        nil,
+		}
+		for path, expected := range tests {
+			hooks, err := hr.DiscoverScriptHooks(HookPhasePre, NewScript(filepath.Join(roots[0], path), roots[0]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, hook := range hooks {
+				names = append(names, hook.Name)
+			}
+			if !reflect.DeepEqual(names, expected) {
+				t.Errorf("%s: expected hooks %v, got %v", path, expected, names)
+			}
 		}
 
-		// Verify args with spaces are quoted but normal args are not
-		if !contains(content, `'arg with spaces'`) {
-			t.Error("Wrapper script missing quoted argument with spaces")
-		}
-		if !contains(content, `normal-arg`) {
-			t.Error("Wrapper script missing unquoted simple argument")
-		}
-		if !contains(content, `'another arg'`) {
-			t.Error("Wrapper script missing second quoted argument with spaces")
+		hooks, _ := hr.DiscoverScriptHooks(HookPhasePre, NewScript(filepath.Join(roots[0], "deploy", "web"), roots[0]))
+		if reason := hooks[1].Reason(); reason != "root hook, tagged prod" {
+			t.Errorf("unexpected reason %q", reason)
 		}
 	})
 }
 
-// TestBuildHookEnv tests environment variable building
-func TestBuildHookEnv(t *testing.T) {
+// TestHookEnv tests the variables describing the script to its hooks
+func TestHookEnv(t *testing.T) {
 	t.Run("builds standard environment variables", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		config := setupTestConfig(t, tmpDir, "tome-cli")
 		hr := NewHookRunner(config)
 
-		env := hr.buildHookEnv("", "/path/to/script", []string{"arg1", "arg2"})
+		env := hr.hookEnv("/path/to/script", []string{"arg1", "arg2"})
 
 		// Check for required variables
 		vars := map[string]bool{
@@ -567,12 +397,11 @@ func TestBuildHookEnv(t *testing.T) {
 		config := setupTestConfig(t, tmpDir, "tome-cli")
 		hr := NewHookRunner(config)
 
-		env := hr.buildHookEnv("", "/path/to/script", []string{"arg1", "arg2", "arg3"})
+		env := hr.hookEnv("/path/to/script", []string{"arg1", "arg2", "arg3"})
 
 		found := false
 		for _, e := range env {
-			// Args are quoted to handle spaces properly in bash
-			if contains(e, `TOME_SCRIPT_ARGS="arg1 arg2 arg3"`) {
+			if e == "TOME_SCRIPT_ARGS=arg1 arg2 arg3" {
 				found = true
 				break
 			}
@@ -588,7 +417,7 @@ func TestBuildHookEnv(t *testing.T) {
 		config := setupTestConfig(t, tmpDir, "my-custom-cli")
 		hr := NewHookRunner(config)
 
-		env := hr.buildHookEnv("", "/path/to/script", []string{})
+		env := hr.hookEnv("/path/to/script", []string{})
 
 		found := false
 		for _, e := range env {
@@ -602,6 +431,43 @@ func TestBuildHookEnv(t *testing.T) {
 			t.Error("Custom executable environment variable not set")
 		}
 	})
+	t.Run("keeps arguments intact", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		hr := NewHookRunner(setupTestConfig(t, tmpDir, "tome-cli"))
+		args := []string{"a b", "it's \"$HOME\"\nnext"}
+
+		vars := map[string]string{}
+		for _, kv := range hr.hookEnv("/path/to/script", args) {
+			key, value, _ := strings.Cut(kv, "=")
+			vars[key] = value
+		}
+
+		expected := map[string]string{
+			"TOME_SCRIPT_ARGS":      "a b it's \"$HOME\"\nnext",
+			"TOME_SCRIPT_ARG_COUNT": "2",
+			"TOME_SCRIPT_ARG_0":     "/path/to/script",
+			"TOME_SCRIPT_ARG_1":     args[0],
+			"TOME_SCRIPT_ARG_2":     args[1],
+		}
+		for key, value := range expected {
+			if vars[key] != value {
+				t.Errorf("expected %s=%q, got %q", key, value, vars[key])
+			}
+		}
+
+		var decoded []string
+		if err := json.Unmarshal([]byte(vars["TOME_SCRIPT_ARGS_JSON"]), &decoded); err != nil || !reflect.DeepEqual(decoded, args) {
+			t.Errorf("expected TOME_SCRIPT_ARGS_JSON to decode to %q, got %q (%v)", args, decoded, err)
+		}
+
+		out, err := exec.Command("/bin/sh", "-c", `eval "set -- $1"; printf '%s\n' "$#" "$2"`, "sh", vars["TOME_SCRIPT_ARGV"]).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "2\n" + args[1] + "\n"; string(out) != expected {
+			t.Errorf("expected TOME_SCRIPT_ARGV to split into %q, got %q", expected, out)
+		}
+	})
 }
 
 // Helper functions
@@ -0,0 +1,392 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+)
+
+// indexVersion is bumped whenever the parsed fields change shape or parsing
+// changes what they hold, so stale indexes from older releases are discarded
+// instead of trusted
+const indexVersion = 2
+
+// IndexEntry is the cached parse result for a single script.
+// An entry is only valid while mtime, size and mode match the file on disk.
+type IndexEntry struct {
+	ModTime        int64       `json:"mtime"`
+	Size           int64       `json:"size"`
+	Mode           os.FileMode `json:"mode"`
+	Usage          string      `json:"usage"`
+	Help           string      `json:"help"`
+	HasCompletions bool        `json:"has_completions"`
+}
+
+func (e *IndexEntry) matches(info os.FileInfo) bool {
+	return e.ModTime == info.ModTime().UnixNano() && e.Size == info.Size() && e.Mode == info.Mode()
+}
+
+// ScriptIndex is an on-disk cache of parsed script headers for one root.
+// It avoids re-reading every script on each help listing or TAB press.
+type ScriptIndex struct {
+	Version   int                    `json:"version"`
+	Root      string                 `json:"root"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Entries   map[string]*IndexEntry `json:"entries"`
+
+	path     string
+	dirty    bool
+	disabled bool
+}
+
+// tomeCacheDir returns the directory used for tome-cli caches,
+// honoring XDG_CACHE_HOME through os.UserCacheDir
+func tomeCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tome-cli"), nil
+}
+
+func indexPathForRoot(root string) (string, error) {
+	cacheDir, err := tomeCacheDir()
+	if err != nil {
+		return "", err
+	}
+	// Relative roots such as the default "." must not share an index across directories
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(cacheDir, "index", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+func newScriptIndex(root string, path string) *ScriptIndex {
+	return &ScriptIndex{
+		Version: indexVersion,
+		Root:    root,
+		Entries: map[string]*IndexEntry{},
+		path:    path,
+	}
+}
+
+// LoadScriptIndex reads the index for root from the cache directory.
+// A missing, unreadable or outdated index yields an empty one, so callers
+// never need to handle errors: the worst case is re-parsing scripts.
+func LoadScriptIndex(root string, enabled bool) *ScriptIndex {
+	path, err := indexPathForRoot(root)
+	if err != nil || !enabled {
+		idx := newScriptIndex(root, path)
+		idx.disabled = true
+		return idx
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debugw("unable to read script index", "path", path, "error", err)
+		}
+		return newScriptIndex(root, path)
+	}
+
+	idx := newScriptIndex(root, path)
+	if err := json.Unmarshal(body, idx); err != nil || idx.Version != indexVersion || idx.Root != root {
+		log.Debugw("discarding invalid script index", "path", path, "error", err)
+		return newScriptIndex(root, path)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string]*IndexEntry{}
+	}
+	return idx
+}
+
+// Lookup stats path and returns its file info along with the parsed script
+// when path is an executable file. Parsing only happens on a cache miss.
+func (idx *ScriptIndex) Lookup(path string) (*Script, os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		idx.forget(path)
+		return nil, nil, err
+	}
+	if info.IsDir() || !isExecutableByOwner(info.Mode()) {
+		idx.forget(path)
+		return nil, info, nil
+	}
+	return idx.script(path, info), info, nil
+}
+
+// Script returns the parsed script for path, or a bare unparsed script
+// when path cannot be stat'ed
+func (idx *ScriptIndex) Script(path string) *Script {
+	info, err := os.Stat(path)
+	if err != nil {
+		idx.forget(path)
+		return &Script{path: path, root: idx.Root}
+	}
+	if info.IsDir() || !isExecutableByOwner(info.Mode()) {
+		// Only executables are indexed, anything else is parsed on demand
+		return NewScript(path, idx.Root)
+	}
+	return idx.script(path, info)
+}
+
+func (idx *ScriptIndex) script(path string, info os.FileInfo) *Script {
+	if entry, ok := idx.Entries[path]; ok && entry.matches(info) {
+		log.Debugw("script index hit", "path", path)
+		return entry.script(path, idx.Root)
+	}
+
+	log.Debugw("script index miss", "path", path)
+	s := NewScript(path, idx.Root)
+	hasCompletions := s.HasCompletions()
+	s.hasCompletions = &hasCompletions
+	idx.Entries[path] = &IndexEntry{
+		ModTime:        info.ModTime().UnixNano(),
+		Size:           info.Size(),
+		Mode:           info.Mode(),
+		Usage:          s.usage,
+		Help:           s.help,
+		HasCompletions: hasCompletions,
+	}
+	idx.dirty = true
+	return s
+}
+
+func (e *IndexEntry) script(path string, root string) *Script {
+	hasCompletions := e.HasCompletions
+	return &Script{
+		path:           path,
+		root:           root,
+		usage:          e.Usage,
+		help:           e.Help,
+		hasCompletions: &hasCompletions,
+	}
+}
+
+func (idx *ScriptIndex) forget(path string) {
+	if _, ok := idx.Entries[path]; ok {
+		delete(idx.Entries, path)
+		idx.dirty = true
+	}
+}
+
+// Prune drops entries for paths not present in keep,
+// used after a full walk of the root to evict deleted scripts
+func (idx *ScriptIndex) Prune(keep []string) {
+	seen := make(map[string]bool, len(keep))
+	for _, p := range keep {
+		seen[p] = true
+	}
+	for p := range idx.Entries {
+		if !seen[p] {
+			idx.forget(p)
+		}
+	}
+}
+
+// Save writes the index back to disk when it has changed.
+// The write goes through a temp file and rename so concurrent
+// completions never observe a partially written index.
+func (idx *ScriptIndex) Save() error {
+	if idx.disabled || !idx.dirty {
+		return nil
+	}
+	idx.UpdatedAt = time.Now().UTC()
+	body, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(idx.path, body); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// writeFileAtomic writes through a temporary file and rename so
+// concurrent readers never observe a partially written file
+func writeFileAtomic(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveOrLog persists the index, logging instead of failing
+// because the index is only an optimization
+func (idx *ScriptIndex) saveOrLog() {
+	if err := idx.Save(); err != nil {
+		log.Debugw("unable to save script index", "path", idx.path, "error", err)
+	}
+}
+
+// Clear removes the on-disk index
+func (idx *ScriptIndex) Clear() error {
+	idx.Entries = map[string]*IndexEntry{}
+	idx.dirty = false
+	err := os.Remove(idx.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Rebuild walks the root and re-parses every executable
+func (idx *ScriptIndex) Rebuild(config *Config) (int, error) {
+	idx.Entries = map[string]*IndexEntry{}
+	executables, err := collectExecutables(idx.Root, config.IgnorePatternsFor(idx.Root))
+	if err != nil {
+		return 0, err
+	}
+	for _, executable := range executables {
+		idx.Script(executable)
+	}
+	idx.dirty = true
+	return len(executables), idx.Save()
+}
+
+// IndexStatus summarizes how many cached entries are still valid
+type IndexStatus struct {
+	Path    string
+	Entries int
+	Fresh   int
+	Stale   int
+	Missing int
+}
+
+func (idx *ScriptIndex) Status() IndexStatus {
+	status := IndexStatus{Path: idx.path, Entries: len(idx.Entries)}
+	for p, entry := range idx.Entries {
+		info, err := os.Stat(p)
+		switch {
+		case err != nil:
+			status.Missing++
+		case entry.matches(info):
+			status.Fresh++
+		default:
+			status.Stale++
+		}
+	}
+	return status
+}
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Manage the cache of parsed script headers",
+	Long: dedent.Dedent(`
+	tome-cli keeps an index of parsed script headers (usage, help and
+	completion support) under the user cache directory so that help
+	and completion do not re-read every script on each invocation.
+
+	Entries are keyed by path and invalidated whenever a script's
+	modification time, size or mode changes, so the index rarely needs
+	manual maintenance. Set TOME_INDEX=false to bypass it entirely.
+	`),
+}
+
+var indexRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Re-parse every script and rewrite the index",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := NewConfig()
+		for _, root := range config.RootDirs() {
+			idx := LoadScriptIndex(root, true)
+			count, err := idx.Rebuild(config)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "indexed %d scripts from %s in %s\n", count, root, idx.path)
+		}
+		return nil
+	},
+}
+
+var indexStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show how many index entries are fresh, stale or missing",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := NewConfig()
+		out := cmd.OutOrStdout()
+		for i, root := range config.RootDirs() {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			printIndexStatus(out, LoadScriptIndex(root, true))
+		}
+		return nil
+	},
+}
+
+func printIndexStatus(out io.Writer, idx *ScriptIndex) {
+	status := idx.Status()
+	fmt.Fprintf(out, "root: %s\n", idx.Root)
+	fmt.Fprintf(out, "path: %s\n", status.Path)
+	if idx.UpdatedAt.IsZero() {
+		fmt.Fprintf(out, "updated: never\n")
+	} else {
+		fmt.Fprintf(out, "updated: %s\n", idx.UpdatedAt.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(out, "entries: %d (fresh: %d, stale: %d, missing: %d)\n", status.Entries, status.Fresh, status.Stale, status.Missing)
+	if debug {
+		paths := make([]string, 0, len(idx.Entries))
+		for p := range idx.Entries {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Fprintf(out, "  %s\n", p)
+		}
+	}
+}
+
+var indexClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete the index of every configured root",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := NewConfig()
+		for _, root := range config.RootDirs() {
+			idx := LoadScriptIndex(root, true)
+			if err := idx.Clear(); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "removed %s\n", idx.path)
+		}
+		return nil
+	},
+}
+
+func init() {
+	indexCmd.AddCommand(indexRebuildCmd)
+	indexCmd.AddCommand(indexStatusCmd)
+	indexCmd.AddCommand(indexClearCmd)
+	rootCmd.AddCommand(indexCmd)
+}
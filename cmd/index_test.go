@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupTestIndex points the user cache dir at a temp directory
+// so tests never read or write the real script index
+func setupTestIndex(t *testing.T) string {
+	t.Helper()
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+	t.Setenv("HOME", cacheDir)
+	return cacheDir
+}
+
+// TestScriptIndex tests caching and invalidation of parsed scripts
+func TestScriptIndex(t *testing.T) {
+	t.Run("caches parsed scripts across loads", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		scriptPath := filepath.Join(tmpDir, "deploy")
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n# USAGE: $0 <env>\n# TOME_COMPLETION\necho 1\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := LoadScriptIndex(tmpDir, true)
+		s := idx.Script(scriptPath)
+		if s.Usage() != "<env>" {
+			t.Errorf("expected usage '<env>', got '%s'", s.Usage())
+		}
+		if err := idx.Save(); err != nil {
+			t.Fatalf("Save() returned error: %v", err)
+		}
+
+		reloaded := LoadScriptIndex(tmpDir, true)
+		entry, ok := reloaded.Entries[scriptPath]
+		if !ok {
+			t.Fatal("expected script to be present in reloaded index")
+		}
+		if entry.Usage != "<env>" || !entry.HasCompletions {
+			t.Errorf("unexpected cached entry: %+v", entry)
+		}
+
+		cached := reloaded.Script(scriptPath)
+		if !cached.HasCompletions() {
+			t.Error("expected cached script to report completions")
+		}
+		if reloaded.dirty {
+			t.Error("index should not be dirty after a cache hit")
+		}
+	})
+
+	t.Run("re-parses scripts whose mtime changed", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		scriptPath := filepath.Join(tmpDir, "deploy")
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n# USAGE: $0 <env>\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := LoadScriptIndex(tmpDir, true)
+		idx.Script(scriptPath)
+		if err := idx.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n# USAGE: $0 <env> <region>\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		if err := os.Chtimes(scriptPath, future, future); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded := LoadScriptIndex(tmpDir, true)
+		if status := reloaded.Status(); status.Stale != 1 {
+			t.Errorf("expected 1 stale entry, got %+v", status)
+		}
+		s := reloaded.Script(scriptPath)
+		if s.Usage() != "<env> <region>" {
+			t.Errorf("expected refreshed usage, got '%s'", s.Usage())
+		}
+	})
+
+	t.Run("discards indexes from older versions", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		scriptPath := filepath.Join(tmpDir, "deploy")
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n# USAGE: $0 TOME_ENV=<env>\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		idx := LoadScriptIndex(tmpDir, true)
+		idx.Script(scriptPath)
+		idx.Version = indexVersion - 1
+		if err := idx.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded := LoadScriptIndex(tmpDir, true)
+		if len(reloaded.Entries) != 0 {
+			t.Errorf("expected an outdated index to be discarded, got %+v", reloaded.Entries)
+		}
+	})
+
+	t.Run("lookup forgets deleted scripts", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		scriptPath := filepath.Join(tmpDir, "deploy")
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := LoadScriptIndex(tmpDir, true)
+		idx.Script(scriptPath)
+		if err := os.Remove(scriptPath); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := idx.Lookup(scriptPath); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error, got %v", err)
+		}
+		if _, ok := idx.Entries[scriptPath]; ok {
+			t.Error("expected deleted script to be evicted from index")
+		}
+	})
+
+	t.Run("lookup does not index directories or plain files", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		if err := os.Mkdir(filepath.Join(tmpDir, "folder"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("hi"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := LoadScriptIndex(tmpDir, true)
+		for _, name := range []string{"folder", "notes.txt"} {
+			s, info, err := idx.Lookup(filepath.Join(tmpDir, name))
+			if err != nil {
+				t.Fatalf("Lookup(%s) returned error: %v", name, err)
+			}
+			if s != nil || info == nil {
+				t.Errorf("Lookup(%s) expected no script and file info, got %v %v", name, s, info)
+			}
+		}
+		if len(idx.Entries) != 0 {
+			t.Errorf("expected empty index, got %d entries", len(idx.Entries))
+		}
+	})
+
+	t.Run("disabled index never writes to disk", func(t *testing.T) {
+		cacheDir := setupTestIndex(t)
+		tmpDir := t.TempDir()
+		setupTestConfig(t, tmpDir, "tome-cli")
+
+		scriptPath := filepath.Join(tmpDir, "deploy")
+		if err := os.WriteFile(scriptPath, []byte("#!/bin/bash\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := LoadScriptIndex(tmpDir, false)
+		idx.Script(scriptPath)
+		if err := idx.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, "tome-cli")); !os.IsNotExist(err) {
+			t.Errorf("expected no cache directory to be created, got %v", err)
+		}
+	})
+
+	t.Run("rebuild and clear", func(t *testing.T) {
+		setupTestIndex(t)
+		tmpDir := t.TempDir()
+		config := setupTestConfig(t, tmpDir, "tome-cli")
+
+		for _, name := range []string{"a", "b"} {
+			if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("#!/bin/bash\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		idx := LoadScriptIndex(tmpDir, true)
+		count, err := idx.Rebuild(config)
+		if err != nil {
+			t.Fatalf("Rebuild() returned error: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("expected 2 indexed scripts, got %d", count)
+		}
+		if _, err := os.Stat(idx.path); err != nil {
+			t.Errorf("expected index file to exist: %v", err)
+		}
+
+		if err := idx.Clear(); err != nil {
+			t.Fatalf("Clear() returned error: %v", err)
+		}
+		if _, err := os.Stat(idx.path); !os.IsNotExist(err) {
+			t.Errorf("expected index file to be removed, got %v", err)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,7 +10,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -29,10 +34,33 @@ func NewCompletionArgs(args []string, currentWord string) CompletionArgs {
 	}
 }
 
+// restoreFlagAwaitingValue puts back a flag which awaits the value being completed.
+// cobra drops a flag sharing a name with one of its own, such as a script declaring -r,
+// from args even after the script's path, commandLine being the arguments of __complete.
+func restoreFlagAwaitingValue(args []string, commandLine []string) []string {
+	for i, arg := range commandLine {
+		if arg != cobra.ShellCompRequestCmd {
+			continue
+		}
+		// The word being completed comes last
+		line := commandLine[i+1 : max(i+1, len(commandLine)-1)]
+		if len(line) <= len(args) {
+			return args
+		}
+		flag := line[len(line)-1]
+		if !strings.HasPrefix(flag, "-") || flag == "-" || flag == "--" || !slices.Equal(line[len(line)-1-len(args):len(line)-1], args) {
+			return args
+		}
+		return append(append([]string{}, args...), flag)
+	}
+	return args
+}
+
 func ValidArgsFunctionForScripts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	args = restoreFlagAwaitingValue(args, os.Args)
 	config := NewConfig()
-	rootDir := config.RootDir()
-	ignorePatterns := config.IgnorePatterns()
+	roots := NewScriptRoots(config)
+	defer roots.Save()
 
 	if debug {
 		cobra.CompDebugln(fmt.Sprintf(`completion: args=%+v, toComplete=%s`, args, toComplete), true)
@@ -40,23 +68,20 @@ func ValidArgsFunctionForScripts(cmd *cobra.Command, args []string, toComplete s
 	// If we have an executable file in the path, we're working on completions for that script itself via --completion
 	var argsAccumulator []string
 	// Iteration must exit on first matching executable file or it breaks invariants of code
-	for _, arg := range args {
+	for i, arg := range args {
 		// __complete is passed as an internal directive
 		if arg == "__complete" {
 			continue
 		}
 		argsAccumulator = append(argsAccumulator, arg)
-		joint := path.Join(append([]string{rootDir}, argsAccumulator...)...)
-		if ignorePatterns.MatchesPath(joint) {
-			continue
-		}
-		f, err := os.Stat(joint)
+		s, f, root, err := roots.Lookup(argsAccumulator)
 		if debug {
-			cobra.CompDebugln(fmt.Sprintf(`completion: joint=%s`, joint), true)
-			cobra.CompDebugln(fmt.Sprintf(`completion: executable=%s`, f.Mode()), true)
+			cobra.CompDebugln(fmt.Sprintf(`completion: segments=%+v root=%s`, argsAccumulator, root), true)
+			if f != nil {
+				cobra.CompDebugln(fmt.Sprintf(`completion: executable=%s`, f.Mode()), true)
+			}
 		}
-		if err == nil && isExecutableByOwner(f.Mode()) {
-			s := NewScript(joint, rootDir)
+		if err == nil && s != nil {
 			// We have an executable file in the path
 			// Handle completion for the script itself via --completion
 
@@ -66,50 +91,41 @@ func ValidArgsFunctionForScripts(cmd *cobra.Command, args []string, toComplete s
 			if debug {
 				cobra.CompDebugln(fmt.Sprintf(`completion: hasCompletions=%t`, s.HasCompletions()), true)
 			}
-			if !s.HasCompletions() {
-				continue
-			}
-
-			/*
-				Extract the completion values from the script
-			*/
-			// Execute the joint path as a shell script
-			completionFlag := []string{"--completion"}
-			cmd := exec.Command(joint, completionFlag...)
-			envArg := NewCompletionArgs(args, toComplete)
-			c, err := json.Marshal(envArg)
-			if err != nil {
-				log.Fatal(err)
-				os.Exit(1)
-			}
-			completionArg := fmt.Sprintf(`TOME_COMPLETION=%s`, c)
-			cmd.Env = append(cmd.Environ(), completionArg)
-			output, err := cmd.CombinedOutput()
-			if debug {
-				cobra.CompDebugln(fmt.Sprintf(`completion: output=%s`, output), true)
-			}
-			if err != nil {
-				return nil, cobra.ShellCompDirectiveError
-			}
 
-			// Split the output into lines
-			lines := strings.Split(string(output), "\n")
+			scriptArgs := args[i+1:]
+			options := s.DeclaredOptions()
+			target, flagValue := completionTarget(options, scriptArgs)
 
-			if debug {
-				cobra.CompDebugln(fmt.Sprintf(`completion: lines=%s`, lines), true)
-			}
-			// Remove empty lines
-			var completions []string
-			for _, line := range lines {
+			// Completions declared with TOME_COMPLETE are resolved without running the script,
+			// unless declared as dynamic which opts into the --completion protocol
+			dynamic := s.HasCompletions()
+			if spec := findCompletionSpec(s.CompletionSpecs(), options, target, flagValue); spec != nil {
 				if debug {
-					cobra.CompDebugln(fmt.Sprintf(`completion: line=%+v`, line), true)
+					cobra.CompDebugln(fmt.Sprintf(`completion: target=%s spec=%+v`, target, spec), true)
 				}
-				if line != "" {
-					completions = append(completions, line)
+				if spec.Kind == CompleteDynamic {
+					dynamic = true
+				} else if !strings.HasPrefix(toComplete, "-") || flagValue {
+					return roots.Complete(*spec, toComplete)
+				}
+			}
+
+			// Flags declared with OPTION: lines complete without running the script
+			if len(options) > 0 {
+				if flagValue {
+					if !dynamic {
+						return nil, cobra.ShellCompDirectiveDefault
+					}
+				} else if strings.HasPrefix(toComplete, "-") || (toComplete == "" && !dynamic) {
+					return optionCompletions(options, scriptArgs, toComplete), cobra.ShellCompDirectiveNoFileComp
 				}
 			}
 
-			return completions, cobra.ShellCompDirectiveNoFileComp
+			if !dynamic {
+				continue
+			}
+
+			return scriptCompletions(config, s, args, toComplete)
 		}
 	}
 
@@ -117,51 +133,49 @@ func ValidArgsFunctionForScripts(cmd *cobra.Command, args []string, toComplete s
 		cobra.CompDebugln(fmt.Sprintf(`completion: argsAccumulator=%+v`, argsAccumulator), true)
 	}
 	// Otherwise we're completing the path to the script
-	fullPathSegments := append([]string{rootDir}, args...)
-	fullPath := path.Join(fullPathSegments...)
-
-	entries, err := os.ReadDir(fullPath)
+	// using the entries of that directory merged across all roots
+	entries := roots.ReadDir(args)
 	// If there are no entries, we can't complete anything
 	// this intentionally returns no completions ahead
-	// TODO: should we check for if.IsDir() instead?
 	if len(entries) == 0 {
 		return nil, cobra.ShellCompDirectiveNoFileComp
 	}
-	if err != nil {
-		return nil, cobra.ShellCompDirectiveError
-	}
 	if debug {
 		cobra.CompDebugln(`completion: starting to complete entries`, true)
 	}
-	var toCompleteEntries []string
+	var toCompleteEntries []DirEntry
 	if toComplete == "" {
 		if debug {
 			cobra.CompDebugln(fmt.Sprintf(`completion: entries %+v`, entries), true)
 		}
-		for _, entry := range entries {
-			toCompleteEntries = append(toCompleteEntries, entry.Name())
-		}
+		toCompleteEntries = entries
 	} else {
 		for _, entry := range entries {
-			if strings.HasPrefix(entry.Name(), toComplete) {
-				toCompleteEntries = append(toCompleteEntries, entry.Name())
+			if strings.HasPrefix(entry.Name, toComplete) {
+				toCompleteEntries = append(toCompleteEntries, entry)
 			}
 		}
 	}
 
 	var executableOrDirectories []string
-	for _, entry := range toCompleteEntries {
-		if ignorePatterns.MatchesPath(entry) {
+	for _, dirEntry := range toCompleteEntries {
+		entry := dirEntry.Name
+		if roots.IgnorePatterns(dirEntry.Root).MatchesPath(entry) {
 			continue
 		}
 		if strings.HasSuffix(entry, "/") {
 			executableOrDirectories = append(executableOrDirectories, entry)
 		} else {
+			fullPath := path.Join(append([]string{dirEntry.Root}, args...)...)
 			fullPathWithEntry := path.Join(fullPath, entry)
-			s := NewScript(fullPathWithEntry, rootDir)
-			if s.IsDir() {
+			s, info, err := roots.Index(dirEntry.Root).Lookup(fullPathWithEntry)
+			if err != nil {
+				// Broken symlinks and races with deletion are not completable
+				continue
+			}
+			if info.IsDir() {
 				executableOrDirectories = append(executableOrDirectories, entry+"\tdirectory")
-			} else if s.IsExecutable() {
+			} else if s != nil {
 				if debug {
 					cobra.CompDebugln(fmt.Sprintf(`completion: fullPath=%s, entry=%s, %+v`, fullPath, entry, s), true)
 				}
@@ -172,6 +186,94 @@ func ValidArgsFunctionForScripts(cmd *cobra.Command, args []string, toComplete s
 	return executableOrDirectories, cobra.ShellCompDirectiveNoFileComp
 }
 
+// scriptCompletions runs the script with --completion, bounded by the configured
+// timeout and served from the completion cache when the script opted into it
+func scriptCompletions(config *Config, s *Script, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cache := NewCompletionCache(s, args, toComplete)
+	if completions, directive, ok := cache.Get(); ok {
+		if debug {
+			cobra.CompDebugln(fmt.Sprintf(`completion: cache hit path=%s`, cache.path), true)
+		}
+		return completions, directive
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.CompletionTimeout())
+	defer cancel()
+
+	/*
+		Extract the completion values from the script
+	*/
+	// Execute the joint path as a shell script
+	completionFlag := []string{"--completion"}
+	cmd := exec.CommandContext(ctx, s.path, completionFlag...)
+	// Children holding stdout open must not outlive the deadline
+	cmd.WaitDelay = 100 * time.Millisecond
+	envArg := NewCompletionArgs(args, toComplete)
+	c, err := json.Marshal(envArg)
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+	completionArg := fmt.Sprintf(`TOME_COMPLETION=%s`, c)
+	cmd.Env = append(cmd.Environ(), completionArg)
+	if s.UsesCompletionV2() {
+		cmd.Env = append(cmd.Env, "TOME_COMPLETION_PROTOCOL=v2")
+	}
+
+	// stderr is kept out of the candidates and only surfaced when debugging
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if debug {
+		cobra.CompDebugln(fmt.Sprintf(`completion: output=%s`, output), true)
+		if stderr.Len() > 0 {
+			cobra.CompDebugln(fmt.Sprintf(`completion: stderr=%s`, stderr.String()), true)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		if debug {
+			cobra.CompDebugln(fmt.Sprintf(`completion: timed out after %s`, config.CompletionTimeout()), true)
+		}
+		return nil, cobra.ShellCompDirectiveError
+	}
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var completions []string
+	var directive cobra.ShellCompDirective
+	if s.UsesCompletionV2() {
+		response, err := ParseCompletionResponse(output)
+		if err != nil {
+			if debug {
+				cobra.CompDebugln(err.Error(), true)
+			}
+			return nil, cobra.ShellCompDirectiveError
+		}
+		completions, directive = response.Cobra()
+	} else {
+		// Split the output into lines
+		lines := strings.Split(string(output), "\n")
+
+		if debug {
+			cobra.CompDebugln(fmt.Sprintf(`completion: lines=%s`, lines), true)
+		}
+		// Remove empty lines
+		for _, line := range lines {
+			if debug {
+				cobra.CompDebugln(fmt.Sprintf(`completion: line=%+v`, line), true)
+			}
+			if line != "" {
+				completions = append(completions, line)
+			}
+		}
+		directive = cobra.ShellCompDirectiveNoFileComp
+	}
+
+	cache.Put(completions, directive)
+	return completions, directive
+}
+
 type customWriter struct {
 	io.Writer
 }
@@ -183,6 +285,9 @@ func (cw customWriter) Sync() error {
 	return nil
 }
 
+var loggerOutput io.Writer
+var registerLoggerSink sync.Once
+
 func createLogger(name string, output io.Writer) *zap.SugaredLogger {
 	// Custom writer technique found here:
 	// - https://github.com/uber-go/zap/issues/979
@@ -213,12 +318,16 @@ func createLogger(name string, output io.Writer) *zap.SugaredLogger {
 	}
 	config.EncoderConfig.FunctionKey = "function"
 
-	err := zap.RegisterSink(customWriterKey, func(u *url.URL) (zap.Sink, error) {
-		return customWriter{output}, nil
+	// A scheme is only registered once, later loggers swap the output it writes to
+	loggerOutput = output
+	registerLoggerSink.Do(func() {
+		err := zap.RegisterSink(customWriterKey, func(u *url.URL) (zap.Sink, error) {
+			return customWriter{loggerOutput}, nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 	})
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	// build a valid custom path
 	customPath := fmt.Sprintf("%s:io", customWriterKey)
@@ -0,0 +1,324 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ScriptMetadata is the structured form of a script header.
+// It is parsed from sections such as DESCRIPTION:, ARGUMENTS: and EXAMPLES:
+//
+//	# USAGE: $0 <environment> [--force]
+//	# DESCRIPTION: Deploy the application
+//	# ARGUMENTS:
+//	#   <environment> - Target environment
+//	# OPTIONS:
+//	#   --force, -f - Skip safety checks
+//	# EXAMPLES:
+//	#   deploy staging
+//	# TAGS: prod, deploy
+//	# OWNER: platform-team
+type ScriptMetadata struct {
+	Description string         `json:"description,omitempty" yaml:"description,omitempty"`
+	Arguments   []ArgumentSpec `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Options     []OptionSpec   `json:"options,omitempty" yaml:"options,omitempty"`
+	Examples    []string       `json:"examples,omitempty" yaml:"examples,omitempty"`
+	Tags        []string       `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Deprecated  string         `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Owner       string         `json:"owner,omitempty" yaml:"owner,omitempty"`
+
+	// sectioned is true when at least one known section heading was found,
+	// otherwise the header is free-form text and rendered as-is
+	sectioned bool
+}
+
+// ArgumentSpec describes a positional argument
+type ArgumentSpec struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool   `json:"required" yaml:"required"`
+	Variadic    bool   `json:"variadic,omitempty" yaml:"variadic,omitempty"`
+}
+
+// OptionSpec describes a flag such as --force, -f
+type OptionSpec struct {
+	Names       []string `json:"names" yaml:"names"`
+	Value       string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+const deprecatedDefaultNotice = "This command is deprecated"
+
+// metadataSections maps accepted headings to their canonical section
+var metadataSections = map[string]string{
+	"DESCRIPTION": "description",
+	"ARGUMENTS":   "arguments",
+	"ARGS":        "arguments",
+	"OPTIONS":     "options",
+	"OPTION":      "option",
+	"FLAGS":       "options",
+	"EXAMPLES":    "examples",
+	"EXAMPLE":     "examples",
+	"TAGS":        "tags",
+	"DEPRECATED":  "deprecated",
+	"OWNER":       "owner",
+}
+
+var sectionHeading = regexp.MustCompile(`^([A-Za-z][A-Za-z ]*):\s*(.*)$`)
+
+// tomeDirective matches marker lines such as TOME_COMPLETION which configure
+// tome-cli itself and are not part of the human readable help
+var tomeDirective = regexp.MustCompile(`^TOME_[A-Z0-9_]+\b`)
+
+// ParseMetadata builds the structured metadata from the help text returned by ParseV2
+func ParseMetadata(help string) *ScriptMetadata {
+	m := &ScriptMetadata{}
+	section := "description"
+	var description []string
+
+	for _, line := range strings.Split(help, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || tomeDirective.MatchString(line) {
+			continue
+		}
+		if strings.HasPrefix(line, UsageKey) || strings.HasPrefix(line, LegacyUsageKey) {
+			continue
+		}
+
+		if match := sectionHeading.FindStringSubmatch(line); match != nil {
+			if canonical, ok := metadataSections[strings.ToUpper(strings.TrimSpace(match[1]))]; ok {
+				m.sectioned = true
+				// OPTION: declares a single option and does not start a section
+				if canonical == "option" {
+					m.addLine("options", strings.TrimSpace(match[2]), &description)
+					continue
+				}
+				section = canonical
+				if value := strings.TrimSpace(match[2]); value != "" {
+					m.addLine(section, value, &description)
+				} else if section == "deprecated" {
+					m.Deprecated = deprecatedDefaultNotice
+				}
+				continue
+			}
+		}
+		m.addLine(section, line, &description)
+	}
+
+	m.Description = strings.Join(description, "\n")
+	return m
+}
+
+func (m *ScriptMetadata) addLine(section string, line string, description *[]string) {
+	switch section {
+	case "description":
+		*description = append(*description, line)
+	case "arguments":
+		m.Arguments = append(m.Arguments, parseArgumentSpec(line))
+	case "options":
+		m.Options = append(m.Options, parseOptionSpec(line))
+	case "examples":
+		m.Examples = append(m.Examples, line)
+	case "tags":
+		for _, tag := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+			m.Tags = append(m.Tags, tag)
+		}
+	case "deprecated":
+		if m.Deprecated == "" || m.Deprecated == deprecatedDefaultNotice {
+			m.Deprecated = line
+		} else {
+			m.Deprecated += " " + line
+		}
+	case "owner":
+		m.Owner = line
+	}
+}
+
+// splitSpecDescription separates "<name> - description" or "<name>: description"
+// and falls back to splitting on the first run of whitespace
+func splitSpecDescription(line string) (string, string) {
+	for _, sep := range []string{" - ", " -- ", ": "} {
+		if idx := strings.Index(line, sep); idx > 0 {
+			return strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+len(sep):])
+		}
+	}
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+	return fields[0], strings.TrimSpace(fields[1])
+}
+
+// extractRequiredness strips (required) or (optional) markers from a description
+func extractRequiredness(description string) (string, *bool) {
+	for _, marker := range []string{"(required)", "(optional)"} {
+		if strings.Contains(description, marker) {
+			required := marker == "(required)"
+			return strings.TrimSpace(strings.Replace(description, marker, "", 1)), &required
+		}
+	}
+	return description, nil
+}
+
+func parseArgumentSpec(line string) ArgumentSpec {
+	spec, description := splitSpecDescription(line)
+	arg := ArgumentSpec{Required: true}
+	if strings.HasSuffix(spec, "...") {
+		arg.Variadic = true
+		spec = strings.TrimSuffix(spec, "...")
+	}
+	switch {
+	case strings.HasPrefix(spec, "[") && strings.HasSuffix(spec, "]"):
+		arg.Required = false
+		spec = strings.TrimSuffix(strings.TrimPrefix(spec, "["), "]")
+	case strings.HasPrefix(spec, "<") && strings.HasSuffix(spec, ">"):
+		spec = strings.TrimSuffix(strings.TrimPrefix(spec, "<"), ">")
+	}
+	if strings.HasSuffix(spec, "...") {
+		arg.Variadic = true
+		spec = strings.TrimSuffix(spec, "...")
+	}
+	arg.Name = spec
+
+	description, required := extractRequiredness(description)
+	if required != nil {
+		arg.Required = *required
+	}
+	arg.Description = description
+	return arg
+}
+
+func parseOptionSpec(line string) OptionSpec {
+	opt := OptionSpec{}
+	rest := line
+	// Consume leading flag names and an optional value placeholder
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		token, remainder, _ := strings.Cut(rest, " ")
+		token = strings.TrimRight(token, ",")
+		switch {
+		case strings.HasPrefix(token, "-") && token != "-" && token != "--":
+			// Accept both "--env, -e" and "--env,-e"
+			for _, part := range strings.Split(token, ",") {
+				name, value, hasValue := strings.Cut(part, "=")
+				opt.Names = append(opt.Names, name)
+				if hasValue {
+					opt.Value = strings.Trim(value, "<>")
+				}
+			}
+		case strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">") && len(opt.Names) > 0:
+			opt.Value = strings.Trim(token, "<>")
+		default:
+			description := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), "- "))
+			description, required := extractRequiredness(description)
+			if required != nil {
+				opt.Required = *required
+			}
+			opt.Description = description
+			return opt
+		}
+		rest = remainder
+	}
+}
+
+// Display returns the option names joined for rendering, e.g. "--force, -f <value>"
+func (o OptionSpec) Display() string {
+	display := strings.Join(o.Names, ", ")
+	if o.Value != "" {
+		display += " <" + o.Value + ">"
+	}
+	return display
+}
+
+// Display returns the argument in usage notation, e.g. "<env>" or "[files...]"
+func (a ArgumentSpec) Display() string {
+	name := a.Name
+	if a.Variadic {
+		name += "..."
+	}
+	if a.Required {
+		return "<" + name + ">"
+	}
+	return "[" + name + "]"
+}
+
+// Render writes the metadata as help text with section headings
+func (m *ScriptMetadata) Render(w io.Writer, command string, usage string) {
+	fmt.Fprintf(w, "Usage: %s", command)
+	if usage != "" {
+		fmt.Fprintf(w, " %s", usage)
+	}
+	fmt.Fprintln(w)
+
+	if m.Deprecated != "" {
+		fmt.Fprintf(w, "\nDEPRECATED: %s\n", m.Deprecated)
+	}
+	if m.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", m.Description)
+	}
+
+	if len(m.Arguments) > 0 {
+		rows := make([][2]string, len(m.Arguments))
+		for i, arg := range m.Arguments {
+			description := arg.Description
+			if !arg.Required {
+				description = strings.TrimSpace(description + " (optional)")
+			}
+			rows[i] = [2]string{arg.Display(), description}
+		}
+		renderSection(w, "Arguments", rows)
+	}
+
+	if len(m.Options) > 0 {
+		rows := make([][2]string, len(m.Options))
+		for i, opt := range m.Options {
+			description := opt.Description
+			if opt.Required {
+				description = strings.TrimSpace(description + " (required)")
+			}
+			rows[i] = [2]string{opt.Display(), description}
+		}
+		renderSection(w, "Options", rows)
+	}
+
+	if len(m.Examples) > 0 {
+		fmt.Fprintf(w, "\nExamples:\n")
+		for _, example := range m.Examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+
+	if m.Owner != "" || len(m.Tags) > 0 {
+		fmt.Fprintln(w)
+		if m.Owner != "" {
+			fmt.Fprintf(w, "Owner: %s\n", m.Owner)
+		}
+		if len(m.Tags) > 0 {
+			fmt.Fprintf(w, "Tags: %s\n", strings.Join(m.Tags, ", "))
+		}
+	}
+}
+
+// renderSection prints a heading followed by aligned name/description rows
+func renderSection(w io.Writer, heading string, rows [][2]string) {
+	width := 0
+	for _, row := range rows {
+		if len(row[0]) > width {
+			width = len(row[0])
+		}
+	}
+	fmt.Fprintf(w, "\n%s:\n", heading)
+	for _, row := range rows {
+		if row[1] == "" {
+			fmt.Fprintf(w, "  %s\n", row[0])
+			continue
+		}
+		fmt.Fprintf(w, "  %-*s  %s\n", width, row[0], row[1])
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseMetadata tests parsing of sectioned script headers
+func TestParseMetadata(t *testing.T) {
+	t.Run("parses all sections", func(t *testing.T) {
+		help := strings.Join([]string{
+			"USAGE: $0 <environment> [services...] [--force]",
+			"DESCRIPTION: Deploy the application",
+			"to the given environment",
+			"ARGUMENTS:",
+			"<environment> - Target environment",
+			"[services...] - Services to deploy",
+			"region: Region to use (optional)",
+			"OPTIONS:",
+			"--force, -f - Skip safety checks",
+			"--env,-e <name> (required) Target environment",
+			"EXAMPLES:",
+			"deploy staging",
+			"TAGS: prod, deploy",
+			"DEPRECATED: use release instead",
+			"OWNER: platform-team",
+			"TOME_COMPLETION",
+		}, "\n")
+
+		m := ParseMetadata(help)
+		if !m.sectioned {
+			t.Error("expected metadata to be sectioned")
+		}
+		if m.Description != "Deploy the application\nto the given environment" {
+			t.Errorf("unexpected description: %q", m.Description)
+		}
+
+		expectedArgs := []ArgumentSpec{
+			{Name: "environment", Description: "Target environment", Required: true},
+			{Name: "services", Description: "Services to deploy", Variadic: true},
+			{Name: "region", Description: "Region to use"},
+		}
+		if !reflect.DeepEqual(m.Arguments, expectedArgs) {
+			t.Errorf("expected arguments %+v, got %+v", expectedArgs, m.Arguments)
+		}
+
+		expectedOpts := []OptionSpec{
+			{Names: []string{"--force", "-f"}, Description: "Skip safety checks"},
+			{Names: []string{"--env", "-e"}, Value: "name", Description: "Target environment", Required: true},
+		}
+		if !reflect.DeepEqual(m.Options, expectedOpts) {
+			t.Errorf("expected options %+v, got %+v", expectedOpts, m.Options)
+		}
+
+		if !reflect.DeepEqual(m.Examples, []string{"deploy staging"}) {
+			t.Errorf("unexpected examples: %v", m.Examples)
+		}
+		if !reflect.DeepEqual(m.Tags, []string{"prod", "deploy"}) {
+			t.Errorf("unexpected tags: %v", m.Tags)
+		}
+		if m.Deprecated != "use release instead" {
+			t.Errorf("unexpected deprecation: %q", m.Deprecated)
+		}
+		if m.Owner != "platform-team" {
+			t.Errorf("unexpected owner: %q", m.Owner)
+		}
+	})
+
+	t.Run("free-form help is not sectioned", func(t *testing.T) {
+		m := ParseMetadata("USAGE: test-hooks\nScript to verify hooks executed correctly")
+		if m.sectioned {
+			t.Error("expected free-form help to not be sectioned")
+		}
+		if m.Description != "Script to verify hooks executed correctly" {
+			t.Errorf("unexpected description: %q", m.Description)
+		}
+	})
+
+	t.Run("bare deprecated heading uses default notice", func(t *testing.T) {
+		m := ParseMetadata("DEPRECATED:")
+		if m.Deprecated != deprecatedDefaultNotice {
+			t.Errorf("unexpected deprecation: %q", m.Deprecated)
+		}
+	})
+}
+
+// TestRenderMetadata tests rendering metadata as help text
+func TestRenderMetadata(t *testing.T) {
+	m := ParseMetadata(strings.Join([]string{
+		"DESCRIPTION: This is a foo script",
+		"ARGUMENTS:",
+		"<arg1> - The first argument",
+		"[longer-arg] - The second argument",
+		"EXAMPLES:",
+		"foo 1 2",
+		"OWNER: platform-team",
+	}, "\n"))
+
+	var buf bytes.Buffer
+	m.Render(&buf, "foo", "<arg1> [longer-arg]")
+
+	expected := `Usage: foo <arg1> [longer-arg]
+
+This is a foo script
+
+Arguments:
+  <arg1>        The first argument
+  [longer-arg]  The second argument (optional)
+
+Examples:
+  foo 1 2
+
+Owner: platform-team
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected render:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OptionKey declares a flag which tome-cli parses on behalf of the script
+//
+//	# OPTION: --env,-e <name> (required) Target environment
+//	# OPTION: --force - Skip safety checks
+//
+// Parsed values are exported as TOME_OPT_ENV and TOME_OPT_FORCE
+// and the remaining positional arguments are passed through to the script.
+const OptionKey = "OPTION:"
+
+// OptionEnvPrefix prefixes the environment variable of every declared option
+const OptionEnvPrefix = "TOME_OPT_"
+
+// ParseOptionDeclarations returns the options declared by OPTION: lines of the help text
+func ParseOptionDeclarations(help string) []OptionSpec {
+	var specs []OptionSpec
+	for _, line := range strings.Split(help, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, OptionKey) {
+			continue
+		}
+		spec := parseOptionSpec(strings.TrimSpace(strings.TrimPrefix(line, OptionKey)))
+		if len(spec.Names) == 0 {
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// DeclaredOptions returns the options the script declares with OPTION: lines
+func (s *Script) DeclaredOptions() []OptionSpec {
+	return ParseOptionDeclarations(s.help)
+}
+
+// Key returns the name the option is exported under, --dry-run becomes dry_run.
+// The first long name is preferred over short names.
+func (o OptionSpec) Key() string {
+	name := o.Names[0]
+	for _, n := range o.Names {
+		if strings.HasPrefix(n, "--") {
+			name = n
+			break
+		}
+	}
+	return strings.ToLower(strings.ReplaceAll(strings.TrimLeft(name, "-"), "-", "_"))
+}
+
+// EnvVar returns the environment variable the option is exported as, e.g. TOME_OPT_ENV
+func (o OptionSpec) EnvVar() string {
+	return OptionEnvPrefix + strings.ToUpper(o.Key())
+}
+
+// ParsedOptions holds the declared options found in the arguments
+// along with the positional arguments left for the script
+type ParsedOptions struct {
+	Specs  []OptionSpec
+	Values map[string]string
+	Args   []string
+}
+
+// ParseOptions consumes the declared options from args.
+// Undeclared flags are passed through untouched, as are -- and everything after it.
+// -h and --help skip the required checks so the script can print its own help.
+func ParseOptions(specs []OptionSpec, args []string) (*ParsedOptions, error) {
+	parsed := &ParsedOptions{Specs: specs, Values: map[string]string{}, Args: []string{}}
+	helpRequested := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			// Kept for scripts which forward what follows it, e.g. to a sub-command
+			parsed.Args = append(parsed.Args, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		spec := findOption(specs, name)
+		if spec == nil {
+			if arg == "-h" || arg == "--help" {
+				helpRequested = true
+			}
+			parsed.Args = append(parsed.Args, arg)
+			continue
+		}
+
+		if spec.Value == "" {
+			if hasValue {
+				return nil, &UsageError{Message: fmt.Sprintf("flag %s does not take a value", name)}
+			}
+			parsed.Values[spec.Key()] = "true"
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, &UsageError{Message: fmt.Sprintf("flag %s requires a value <%s>", name, spec.Value)}
+			}
+			i++
+			value = args[i]
+		}
+		parsed.Values[spec.Key()] = value
+	}
+
+	if helpRequested {
+		return parsed, nil
+	}
+	for _, spec := range specs {
+		if _, ok := parsed.Values[spec.Key()]; spec.Required && !ok {
+			return nil, &UsageError{Message: fmt.Sprintf("missing required flag %s", spec.Display())}
+		}
+	}
+	return parsed, nil
+}
+
+func findOption(specs []OptionSpec, name string) *OptionSpec {
+	if !strings.HasPrefix(name, "-") || name == "-" {
+		return nil
+	}
+	for i := range specs {
+		if containsString(specs[i].Names, name) {
+			return &specs[i]
+		}
+	}
+	return nil
+}
+
+// Env returns the TOME_OPT_ variables for every option which was given
+// and TOME_ARGS_JSON describing all options and positional arguments
+func (p *ParsedOptions) Env() ([]string, error) {
+	var envs []string
+	for _, spec := range p.Specs {
+		if value, ok := p.Values[spec.Key()]; ok {
+			envs = append(envs, fmt.Sprintf("%s=%s", spec.EnvVar(), value))
+		}
+	}
+	blob, err := p.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return append(envs, fmt.Sprintf("TOME_ARGS_JSON=%s", blob)), nil
+}
+
+// JSON encodes the options and positional arguments. Flags without a value
+// are booleans, options which were not given are null.
+func (p *ParsedOptions) JSON() (string, error) {
+	options := map[string]interface{}{}
+	for _, spec := range p.Specs {
+		value, ok := p.Values[spec.Key()]
+		switch {
+		case spec.Value == "":
+			options[spec.Key()] = ok
+		case ok:
+			options[spec.Key()] = value
+		default:
+			options[spec.Key()] = nil
+		}
+	}
+	blob, err := json.Marshal(struct {
+		Options map[string]interface{} `json:"options"`
+		Args    []string               `json:"args"`
+	}{options, p.Args})
+	if err != nil {
+		return "", err
+	}
+	return string(blob), nil
+}
+
+// optionCompletions returns the declared flags not yet present in args,
+// each annotated with its description for shells supporting it
+func optionCompletions(specs []OptionSpec, args []string, toComplete string) []string {
+	used := map[string]bool{}
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		if spec := findOption(specs, name); spec != nil {
+			used[spec.Key()] = true
+		}
+	}
+
+	var completions []string
+	for _, spec := range specs {
+		if used[spec.Key()] {
+			continue
+		}
+		for _, name := range spec.Names {
+			if !strings.HasPrefix(name, toComplete) {
+				continue
+			}
+			if spec.Description != "" {
+				completions = append(completions, name+"\t"+spec.Description)
+			} else {
+				completions = append(completions, name)
+			}
+		}
+	}
+	return completions
+}
+
+// expectsOptionValue reports whether the last arg is a declared option awaiting its value
+func expectsOptionValue(specs []OptionSpec, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	last := args[len(args)-1]
+	spec := findOption(specs, last)
+	return spec != nil && spec.Value != "" && !strings.Contains(last, "=")
+}
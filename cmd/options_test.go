@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseOptionDeclarations tests reading OPTION: lines from a header
+func TestParseOptionDeclarations(t *testing.T) {
+	help := strings.Join([]string{
+		"USAGE: $0 <service>",
+		"Deploys a service",
+		"OPTION: --env,-e <name> (required) Target environment",
+		"OPTION: --dry-run - Print what would happen",
+		"Not an option line",
+	}, "\n")
+
+	specs := ParseOptionDeclarations(help)
+	expected := []OptionSpec{
+		{Names: []string{"--env", "-e"}, Value: "name", Description: "Target environment", Required: true},
+		{Names: []string{"--dry-run"}, Description: "Print what would happen"},
+	}
+	if !reflect.DeepEqual(specs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, specs)
+	}
+	if specs[1].EnvVar() != "TOME_OPT_DRY_RUN" {
+		t.Errorf("expected TOME_OPT_DRY_RUN, got %s", specs[1].EnvVar())
+	}
+
+	m := ParseMetadata(help)
+	if len(m.Options) != 2 {
+		t.Errorf("expected declared options in metadata, got %+v", m.Options)
+	}
+}
+
+// TestParseOptions tests consuming declared options from arguments
+func TestParseOptions(t *testing.T) {
+	specs := []OptionSpec{
+		{Names: []string{"--env", "-e"}, Value: "name", Required: true},
+		{Names: []string{"--force"}},
+	}
+
+	t.Run("consumes options and passes positionals", func(t *testing.T) {
+		parsed, err := ParseOptions(specs, []string{"api", "-e", "prod", "--force", "--verbose", "--", "--env"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed.Args, []string{"api", "--verbose", "--", "--env"}) {
+			t.Errorf("unexpected args: %v", parsed.Args)
+		}
+
+		envs, err := parsed.Env()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			"TOME_OPT_ENV=prod",
+			"TOME_OPT_FORCE=true",
+			`TOME_ARGS_JSON={"options":{"env":"prod","force":true},"args":["api","--verbose","--","--env"]}`,
+		}
+		if !reflect.DeepEqual(envs, expected) {
+			t.Errorf("expected %v, got %v", expected, envs)
+		}
+	})
+
+	t.Run("accepts equals form", func(t *testing.T) {
+		parsed, err := ParseOptions(specs, []string{"--env=staging"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Values["env"] != "staging" {
+			t.Errorf("expected env=staging, got %v", parsed.Values)
+		}
+		blob, _ := parsed.JSON()
+		if blob != `{"options":{"env":"staging","force":false},"args":[]}` {
+			t.Errorf("unexpected json: %s", blob)
+		}
+	})
+
+	errorTests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"missing required", []string{"api"}, "missing required flag --env, -e <name>"},
+		{"missing value", []string{"--env"}, "flag --env requires a value <name>"},
+		{"value on boolean", []string{"-e", "prod", "--force=yes"}, "flag --force does not take a value"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOptions(specs, tt.args)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("help skips required check", func(t *testing.T) {
+		parsed, err := ParseOptions(specs, []string{"--help"})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(parsed.Args, []string{"--help"}) {
+			t.Errorf("expected --help passed through, got %v", parsed.Args)
+		}
+	})
+}
+
+// TestOptionCompletions tests completion of declared flags
+func TestOptionCompletions(t *testing.T) {
+	specs := []OptionSpec{
+		{Names: []string{"--env", "-e"}, Value: "name", Description: "Target environment"},
+		{Names: []string{"--force"}},
+	}
+
+	got := optionCompletions(specs, []string{"--env=prod"}, "--")
+	if !reflect.DeepEqual(got, []string{"--force"}) {
+		t.Errorf("expected only unused flags, got %v", got)
+	}
+
+	got = optionCompletions(specs, nil, "--e")
+	if !reflect.DeepEqual(got, []string{"--env\tTarget environment"}) {
+		t.Errorf("expected --env with description, got %v", got)
+	}
+
+	if !expectsOptionValue(specs, []string{"api", "-e"}) {
+		t.Error("expected -e to await a value")
+	}
+	if expectsOptionValue(specs, []string{"--force"}) {
+		t.Error("expected boolean flag not to await a value")
+	}
+}
+
+// executeRootCmd runs args through rootCmd as main does, returning what it wrote
+func executeRootCmd(t *testing.T, args ...string) string {
+	t.Helper()
+	var out bytes.Buffer
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(&out)
+	t.Cleanup(func() {
+		rootCmd.SetArgs(nil)
+		rootCmd.SetOut(nil)
+	})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out.String()
+}
+
+// setupTestRun runs scripts executed through rootCmd as children, so they don't replace the test,
+// away from the user's config and history
+func setupTestRun(t *testing.T) {
+	t.Helper()
+	chdirTest(t, t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("TOME_CLI_SUPERVISE", "true")
+}
+
+// TestDeclaredOptionsCommandLine tests that flags after the script's path reach the script,
+// even those sharing a name with tome-cli's own flags
+func TestDeclaredOptionsCommandLine(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	setupTestRun(t)
+	output := filepath.Join(t.TempDir(), "output")
+	t.Setenv("OUTPUT", output)
+	writeTestScript(t, filepath.Join(roots[0], "dep"), `#!/bin/sh
+# USAGE: $0 [--region <name>] <environment>
+# OPTION: --region,-r <name> Region to deploy to
+echo "$TOME_OPT_REGION $*" > "$OUTPUT"
+`)
+
+	cases := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"script flags", []string{"dep", "--region", "eu", "prod"}, "eu prod"},
+		{"exec", []string{"exec", "dep", "--region=eu", "prod"}, "eu prod"},
+		{"shorthand of a tome-cli flag", []string{"dep", "-r", "eu", "prod", "-d"}, "eu prod -d"},
+		{"-- is passed on", []string{"dep", "--", "--region", "eu"}, " -- --region eu"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			executeRootCmd(t, tc.args...)
+			if got := strings.TrimSuffix(string(mustReadFile(t, output)), "\n"); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	t.Run("completes declared flags", func(t *testing.T) {
+		for _, args := range [][]string{{"dep", "-"}, {"exec", "dep", "-"}} {
+			got := executeRootCmd(t, append([]string{"__complete"}, args...)...)
+			if !strings.Contains(got, "--region\tRegion to deploy to") || strings.Contains(got, "--root") {
+				t.Errorf("expected the declared flags for %v, got:\n%s", args, got)
+			}
+		}
+	})
+
+	t.Run("restores a flag of its own cobra dropped", func(t *testing.T) {
+		commandLine := []string{"tome-cli", "__complete", "-r", "/scripts", "dep", "-r", ""}
+		if got := restoreFlagAwaitingValue([]string{"dep"}, commandLine); !reflect.DeepEqual(got, []string{"dep", "-r"}) {
+			t.Errorf("expected -r to be restored, got %v", got)
+		}
+		if got := restoreFlagAwaitingValue([]string{"dep"}, []string{"tome-cli", "__complete", "-r", "/scripts", "dep", ""}); !reflect.DeepEqual(got, []string{"dep"}) {
+			t.Errorf("expected args to be kept, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// promptListSize is the number of candidates listed at once, typing narrows them down
+const promptListSize = 10
+
+// PromptMarker in a script's header opts it into asking for missing arguments
+const PromptMarker = "TOME_PROMPT"
+
+// ArgumentPrompt asks for a required argument missing from the command line
+type ArgumentPrompt struct {
+	Name        string
+	Description string
+	Candidates  []CompletionCandidate
+	Restricted  bool // only a candidate is accepted, as for {a|b} choices
+}
+
+// PromptEnabled reports whether missing arguments of every script are asked for on a terminal,
+// by prompt: true or TOME_PROMPT=true
+func (c *Config) PromptEnabled() bool {
+	prompt, _ := strconv.ParseBool(c.EnvVarOrViperValue("prompt"))
+	return prompt
+}
+
+// Prompts reports whether the script opted into asking for its missing arguments
+func (s *Script) Prompts() bool {
+	return strings.Contains(s.help, PromptMarker)
+}
+
+// PromptFor builds the prompt for param, the positional at position after args,
+// with candidates from the usage choices or the script's completions
+func (r *ScriptRoots) PromptFor(config *Config, s *Script, param UsageParam, position int, args []string) ArgumentPrompt {
+	prompt := ArgumentPrompt{Name: param.Name}
+	for _, arg := range s.Metadata().Arguments {
+		if arg.Name == param.Name {
+			prompt.Description = arg.Description
+		}
+	}
+	if len(param.Choices) > 0 {
+		for _, choice := range param.Choices {
+			prompt.Candidates = append(prompt.Candidates, CompletionCandidate{Value: choice})
+		}
+		prompt.Restricted = true
+		return prompt
+	}
+	prompt.Candidates = r.argumentCandidates(config, s, position, args)
+	return prompt
+}
+
+// argumentCandidates completes the positional at position like the shell would,
+// files and directories are left to typing
+func (r *ScriptRoots) argumentCandidates(config *Config, s *Script, position int, args []string) []CompletionCandidate {
+	var completions []string
+	var directive cobra.ShellCompDirective
+	spec := findCompletionSpec(s.CompletionSpecs(), s.DeclaredOptions(), strconv.Itoa(position), false)
+	switch {
+	case spec != nil && spec.Kind != CompleteDynamic:
+		completions, directive = r.Complete(*spec, "")
+	case spec != nil || s.HasCompletions():
+		completions, directive = scriptCompletions(config, s, append(s.PathSegments(), args...), "")
+	default:
+		return nil
+	}
+	if directive&(cobra.ShellCompDirectiveError|cobra.ShellCompDirectiveFilterFileExt|cobra.ShellCompDirectiveFilterDirs) != 0 {
+		return nil
+	}
+
+	var candidates []CompletionCandidate
+	for _, completion := range completions {
+		value, description, _ := strings.Cut(completion, "\t")
+		candidates = append(candidates, CompletionCandidate{Value: value, Description: description})
+	}
+	return candidates
+}
+
+// Ask reads the argument from in, writing the prompt and candidates to out.
+// A number selects a listed candidate and other answers narrow the candidates down by fuzzy matching.
+// Unless restricted, an answer matching no candidate is taken as is, as is repeating an answer.
+func (p *ArgumentPrompt) Ask(in *bufio.Reader, out io.Writer) (string, error) {
+	if p.Description != "" {
+		fmt.Fprintf(out, "<%s> %s\n", p.Name, p.Description)
+	}
+	listed := p.Candidates
+	query := ""
+	for {
+		printCandidates(out, listed)
+		fmt.Fprintf(out, "%s: ", p.Name)
+		line, err := in.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if err != nil && answer == "" {
+			fmt.Fprintln(out)
+			return "", fmt.Errorf("no value given for <%s>", p.Name)
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= min(len(listed), promptListSize) {
+			return listed[n-1].Value, nil
+		}
+		for _, candidate := range p.Candidates {
+			if candidate.Value == answer && answer != "" {
+				return answer, nil
+			}
+		}
+		if answer == "" {
+			if query != "" && !p.Restricted {
+				return query, nil
+			}
+			continue
+		}
+		if answer == query && !p.Restricted {
+			return answer, nil
+		}
+
+		matches := fuzzyFilter(answer, p.Candidates)
+		switch {
+		case len(matches) == 1 && p.Restricted:
+			return matches[0].Value, nil
+		case len(matches) == 0 && p.Restricted:
+			fmt.Fprintf(out, "%q matches none of the choices\n", answer)
+			listed = p.Candidates
+		case len(matches) == 0:
+			return answer, nil
+		default:
+			fmt.Fprintf(out, "Pick a number, or press enter to use %q\n", answer)
+			listed = matches
+		}
+		query = answer
+	}
+}
+
+func printCandidates(out io.Writer, candidates []CompletionCandidate) {
+	for i, candidate := range candidates {
+		if i == promptListSize {
+			fmt.Fprintf(out, "  ... %d more, type to narrow down\n", len(candidates)-promptListSize)
+			break
+		}
+		if candidate.Description != "" {
+			fmt.Fprintf(out, "  %d) %s - %s\n", i+1, candidate.Value, candidate.Description)
+		} else {
+			fmt.Fprintf(out, "  %d) %s\n", i+1, candidate.Value)
+		}
+	}
+}
+
+// fuzzyFilter returns the candidates containing the characters of query in order, ignoring case.
+// Prefix matches come first, then substring matches, then the remaining matches.
+func fuzzyFilter(query string, candidates []CompletionCandidate) []CompletionCandidate {
+	query = strings.ToLower(query)
+	type match struct {
+		candidate CompletionCandidate
+		rank      int
+	}
+	var matches []match
+	for _, candidate := range candidates {
+		value := strings.ToLower(candidate.Value)
+		switch {
+		case strings.HasPrefix(value, query):
+			matches = append(matches, match{candidate, 0})
+		case strings.Contains(value, query):
+			matches = append(matches, match{candidate, 1})
+		case isSubsequence(query, value):
+			matches = append(matches, match{candidate, 2})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank < matches[j].rank })
+
+	filtered := make([]CompletionCandidate, len(matches))
+	for i, m := range matches {
+		filtered[i] = m.candidate
+	}
+	return filtered
+}
+
+func isSubsequence(sub string, s string) bool {
+	rest := s
+	for _, r := range sub {
+		i := strings.IndexRune(rest, r)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(string(r)):]
+	}
+	return true
+}
+
+// promptMissingArgs asks for each required argument args leaves out and returns the answers in order.
+// Candidates are completed with the answers given so far.
+func promptMissingArgs(config *Config, roots *ScriptRoots, s *Script, args []string, in io.Reader, out io.Writer) ([]string, error) {
+	grammar := s.UsageGrammar()
+	if grammar == nil {
+		return nil, nil
+	}
+	missing := grammar.MissingArguments(args)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	given, _, _ := grammar.positionals(args)
+	fmt.Fprintf(out, "%s is missing %d required argument(s)\n", strings.Join(s.PathSegments(), " "), len(missing))
+	reader := bufio.NewReader(in)
+	var answers []string
+	for i, param := range missing {
+		prompt := roots.PromptFor(config, s, param, len(given)+i+1, append(append([]string{}, args...), answers...))
+		answer, err := prompt.Ask(reader, out)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, answer)
+	}
+	return answers, nil
+}
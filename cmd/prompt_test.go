@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestArgumentPromptAsk tests picking, narrowing down and typing answers
+func TestArgumentPromptAsk(t *testing.T) {
+	candidates := []CompletionCandidate{{Value: "staging"}, {Value: "production"}, {Value: "prod-eu"}}
+	cases := []struct {
+		name       string
+		restricted bool
+		input      string
+		expected   string
+	}{
+		{"number picks a listed candidate", false, "2\n", "production"},
+		{"candidate typed in full", false, "prod-eu\n", "prod-eu"},
+		{"fuzzy match narrows the list", false, "pr\n2\n", "prod-eu"},
+		{"enter keeps a narrowed answer", false, "prod\n\n", "prod"},
+		{"no match is taken as is", false, "qa\n", "qa"},
+		{"single match of choices is picked", true, "stg\n", "staging"},
+		{"choices are insisted on", true, "qa\nproduction\n", "production"},
+		{"empty answers are asked again", true, "\n1\n", "staging"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			prompt := ArgumentPrompt{Name: "environment", Candidates: candidates, Restricted: tc.restricted}
+			var out bytes.Buffer
+			got, err := prompt.Ask(bufio.NewReader(strings.NewReader(tc.input)), &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %q, got %q, prompted:\n%s", tc.expected, got, out.String())
+			}
+		})
+	}
+
+	t.Run("end of input is an error", func(t *testing.T) {
+		prompt := ArgumentPrompt{Name: "environment"}
+		if _, err := prompt.Ask(bufio.NewReader(strings.NewReader("")), &bytes.Buffer{}); err == nil {
+			t.Error("expected an error without an answer")
+		}
+	})
+}
+
+// TestFuzzyFilter tests ranking of fuzzy matches
+func TestFuzzyFilter(t *testing.T) {
+	candidates := []CompletionCandidate{{Value: "db-restore"}, {Value: "Restore"}, {Value: "reset"}, {Value: "deploy"}}
+	var got []string
+	for _, c := range fuzzyFilter("res", candidates) {
+		got = append(got, c.Value)
+	}
+	expected := []string{"Restore", "reset", "db-restore"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// TestPromptMissingArgs tests asking for each missing argument with the script's descriptions and completions
+func TestPromptMissingArgs(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	config := NewConfig()
+	path := filepath.Join(roots[0], "db", "restore")
+	writeTestScript(t, path, `#!/bin/sh
+# USAGE: $0 <environment> <snapshot> {full|schema}
+# ARGUMENTS:
+#   <environment> - Environment to restore into
+# TOME_COMPLETE 1: values staging production
+# TOME_COMPLETION
+if [ "$1" = "--completion" ]; then
+  echo "nightly	Taken every night"
+  echo "weekly"
+fi
+`)
+	script := NewScript(path, roots[0])
+	scriptRoots := NewScriptRoots(config)
+
+	var out bytes.Buffer
+	answers, err := promptMissingArgs(config, scriptRoots, script, nil, strings.NewReader("prod\n1\n1\nsch\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"production", "nightly", "schema"}; !reflect.DeepEqual(answers, expected) {
+		t.Errorf("expected %v, got %v", expected, answers)
+	}
+	for _, shown := range []string{"missing 3 required argument(s)", "<environment> Environment to restore into", "1) nightly - Taken every night"} {
+		if !strings.Contains(out.String(), shown) {
+			t.Errorf("expected %q to be shown, got:\n%s", shown, out.String())
+		}
+	}
+
+	answers, err = promptMissingArgs(config, scriptRoots, script, []string{"staging", "weekly", "full"}, strings.NewReader(""), &out)
+	if err != nil || answers != nil {
+		t.Errorf("expected nothing to be asked, got %v, %v", answers, err)
+	}
+
+	for _, header := range []string{"# SUMMARY: Restart the web servers", "# USAGE: script.sh [options] restart"} {
+		summary := filepath.Join(roots[0], "web", "restart")
+		writeTestScript(t, summary, "#!/bin/sh\n"+header+"\n")
+		answers, err = promptMissingArgs(config, scriptRoots, NewScript(summary, roots[0]), nil, strings.NewReader(""), &out)
+		if err != nil || answers != nil {
+			t.Errorf("expected nothing to be asked for %q, got %v, %v", header, answers, err)
+		}
+	}
+}
+
+// TestPromptOptIn tests that prompting is off unless configured or declared by the script
+func TestPromptOptIn(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	config := setupTestConfig(t, roots[0], "tome-cli")
+	plain := filepath.Join(roots[0], "plain")
+	writeTestScript(t, plain, "#!/bin/sh\n# USAGE: $0 <environment>\n")
+	opted := filepath.Join(roots[0], "opted")
+	writeTestScript(t, opted, "#!/bin/sh\n# USAGE: $0 <environment>\n# TOME_PROMPT\n")
+
+	if config.PromptEnabled() {
+		t.Error("expected prompting to be off by default")
+	}
+	if NewScript(plain, roots[0]).Prompts() {
+		t.Error("expected a script without TOME_PROMPT not to prompt")
+	}
+	if !NewScript(opted, roots[0]).Prompts() {
+		t.Error("expected a script with TOME_PROMPT to prompt")
+	}
+	t.Setenv("TOME_CLI_PROMPT", "true")
+	if !config.PromptEnabled() {
+		t.Error("expected TOME_CLI_PROMPT=true to enable prompting")
+	}
+}
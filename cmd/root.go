@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/gobeam/stringy"
 	"github.com/spf13/cobra"
@@ -14,7 +15,7 @@ import (
 	"go.uber.org/zap"
 )
 
-var rootDir string
+var rootDirs []string
 var executableName string
 var debug bool
 
@@ -27,7 +28,8 @@ var rootCmd = &cobra.Command{
 It succeeds sub and tome as a third generation that borrows much of it's design from those projects.
 
 It provides a convenient way to organize and execute scripts within a project.
-By loading the context of the full git repository, tome-cli enables you to access and execute scripts specific to your project. It leverages the power of Cobra, a CLI library for Go, to provide a user-friendly and efficient command-line interface.
+By loading the context of the full git repository, tome-cli enables you to access and execute scripts specific to your project.
+Run anywhere inside a repository, it walks up to find the project's scripts in .tome/, scripts/ marked with .tomeroot or the root named by tome.yaml, after any configured root. It leverages the power of Cobra, a CLI library for Go, to provide a user-friendly and efficient command-line interface.
 For more information and usage examples, please refer to the documentation and examples provided in the repository.`,
 	// Bare command is `exec` and it requires at least one argument
 	Args: cobra.MinimumNArgs(1),
@@ -55,14 +57,21 @@ func init() {
 	// the flag default values will override anything in config file :-/
 	// Instead we tried bindFlags from https://github.com/carolynvs/stingoftheviper/blob/main/main.go#L111-L128
 	// But that seems to break the environment variable binding
-	rootCmd.PersistentFlags().StringVarP(&rootDir, "root", "r", ".", "root directory containing scripts")
+	rootCmd.PersistentFlags().StringArrayVarP(&rootDirs, "root", "r", []string{"."}, "root directory containing scripts (repeatable, earlier roots take precedence, the discovered project root comes last)")
 	rootCmd.PersistentFlags().StringVarP(&executableName, "executable", "e", "", "executable name")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug logs")
-	viper.BindPFlag("root", rootCmd.PersistentFlags().Lookup("root"))
-	viper.BindPFlag("executable", rootCmd.PersistentFlags().Lookup("executable"))
-	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
-	viper.SetDefault("author", "Zander Hill <zander@xargs.io>")
-	viper.SetDefault("license", "mit")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Run scripts declaring TOME_CONFIRM or TOME_DANGEROUS without asking")
+	bindConfigFlag("root", rootCmd.PersistentFlags().Lookup("root"))
+	bindConfigFlag("executable", rootCmd.PersistentFlags().Lookup("executable"))
+	bindConfigFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	bindConfigFlag("yes", rootCmd.PersistentFlags().Lookup("yes"))
+	// Flags after the script's path belong to the script, e.g. its declared options
+	rootCmd.Flags().SetInterspersed(false)
+	viper.SetDefault("index", true)
+	viper.SetDefault("discover", true)
+	viper.SetDefault("history", true)
+	viper.SetDefault("confirm_without_tty", ConfirmWithoutTTYFail)
+	viper.SetDefault("completion_timeout", defaultCompletionTimeout.String())
 }
 
 var log *zap.SugaredLogger
@@ -71,12 +80,8 @@ var log *zap.SugaredLogger
 func initConfig() {
 	log = createLogger("initConfig", rootCmd.OutOrStderr())
 	v := viper.GetViper()
-	var err error
-	rootDir, err = filepath.Abs(rootDir)
-	log.Debug("rootDir", rootDir)
-	if err != nil {
-		panic(fmt.Sprintf(`Unable to determine absolute path for root directory: %e`, err))
-	}
+	// Roots are made absolute by Config.RootDirs after splitting colon separated lists
+	log.Debugw("rootDirs from flags", "roots", rootDirs)
 
 	log.Debugw("executableName from flags", "var", executableName)
 	if executableName == "" {
@@ -95,4 +100,18 @@ func initConfig() {
 	// it is set to support multiple instances of the cli
 	v.SetEnvPrefix("TOME") // will be uppercased automatically
 	v.AutomaticEnv()       // read in environment variables that match
+
+	// Config files rank below flags and environment variables
+	if err := LoadConfigFiles(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if name := v.GetString("executable"); name != "" && !NewConfig().flagChanged("executable") {
+		log.Debugw("executableName from config", "var", name)
+		executableName = name
+	}
+	// As --debug does, once config files and variables are known
+	if enabled, _ := strconv.ParseBool(NewConfig().EnvVarOrViperValue("debug")); enabled && !debug {
+		debug = true
+		log = createLogger("initConfig", rootCmd.OutOrStderr())
+	}
 }
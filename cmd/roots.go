@@ -0,0 +1,239 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// ScriptRoots merges an ordered list of root directories into one command tree.
+// When two roots provide the same relative path the earlier root wins,
+// so a personal root listed first can override a shared team root.
+type ScriptRoots struct {
+	config  *Config
+	dirs    []string
+	ignores map[string]*gitignore.GitIgnore
+	indexes map[string]*ScriptIndex
+}
+
+// ShadowedScript is an executable hidden by a script or directory in an earlier root
+type ShadowedScript struct {
+	Script     *Script
+	ShadowedBy string
+}
+
+func NewScriptRoots(config *Config) *ScriptRoots {
+	return &ScriptRoots{
+		config:  config,
+		dirs:    config.RootDirs(),
+		ignores: map[string]*gitignore.GitIgnore{},
+		indexes: map[string]*ScriptIndex{},
+	}
+}
+
+func (r *ScriptRoots) Dirs() []string {
+	return r.dirs
+}
+
+// Multiple reports whether more than one root is configured,
+// which is when output needs to mention where a script came from
+func (r *ScriptRoots) Multiple() bool {
+	return len(r.dirs) > 1
+}
+
+func (r *ScriptRoots) IgnorePatterns(root string) *gitignore.GitIgnore {
+	if ignore, ok := r.ignores[root]; ok {
+		return ignore
+	}
+	ignore := r.config.IgnorePatternsFor(root)
+	r.ignores[root] = ignore
+	return ignore
+}
+
+func (r *ScriptRoots) Index(root string) *ScriptIndex {
+	if idx, ok := r.indexes[root]; ok {
+		return idx
+	}
+	idx := LoadScriptIndex(root, r.config.IndexEnabled())
+	r.indexes[root] = idx
+	return idx
+}
+
+// Save persists every index that was loaded
+func (r *ScriptRoots) Save() {
+	for _, idx := range r.indexes {
+		idx.saveOrLog()
+	}
+}
+
+// Lookup finds the first root containing the relative path made of segments.
+// The returned script is nil for directories and non-executable files.
+func (r *ScriptRoots) Lookup(segments []string) (*Script, os.FileInfo, string, error) {
+	var firstErr error
+	for _, root := range r.dirs {
+		joint := path.Join(append([]string{root}, segments...)...)
+		if r.IgnorePatterns(root).MatchesPath(joint) {
+			continue
+		}
+		s, info, err := r.Index(root).Lookup(joint)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return s, info, root, nil
+	}
+	if firstErr == nil {
+		firstErr = os.ErrNotExist
+	}
+	return nil, nil, "", firstErr
+}
+
+// IsDir reports whether segments name a directory in the merged tree
+func (r *ScriptRoots) IsDir(segments []string) bool {
+	_, info, _, err := r.Lookup(segments)
+	return err == nil && info.IsDir()
+}
+
+// Resolve finds the executable named by the leading args and returns it with
+// the remaining args. Each root is tried in order with the same algorithm,
+// joining one arg at a time until an executable file is found.
+func (r *ScriptRoots) Resolve(args []string) (*Script, []string) {
+	for _, root := range r.dirs {
+		idx := r.Index(root)
+		maybeFile := root
+		for i, arg := range args {
+			maybeFile = path.Join(maybeFile, arg)
+			s, info, err := idx.Lookup(maybeFile)
+			if err != nil {
+				log.Debugw("unable to resolve script segment", "path", maybeFile, "error", err)
+				continue
+			}
+			if info.IsDir() {
+				continue
+			}
+			if s != nil {
+				log.Debugw("resolved script", "path", maybeFile, "root", root)
+				return s, args[i+1:]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// DirEntry is a directory listing entry annotated with the root providing it
+type DirEntry struct {
+	Name string
+	Root string
+}
+
+// ReadDir lists the merged entries of the directory named by segments across all roots
+func (r *ScriptRoots) ReadDir(segments []string) []DirEntry {
+	seen := map[string]bool{}
+	var entries []DirEntry
+	for _, root := range r.dirs {
+		dirEntries, err := os.ReadDir(path.Join(append([]string{root}, segments...)...))
+		if err != nil {
+			continue
+		}
+		for _, entry := range dirEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			entries = append(entries, DirEntry{Name: entry.Name(), Root: root})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Collect returns every reachable executable across roots ordered by command path,
+// along with the executables hidden by an earlier root
+func (r *ScriptRoots) Collect() ([]*Script, []ShadowedScript, error) {
+	var scripts []*Script
+	var shadowed []ShadowedScript
+	// owners maps a relative path to the root which first provided it
+	owners := map[string]string{}
+	executables := map[string]bool{}
+
+	for _, root := range r.dirs {
+		paths, err := collectExecutables(root, r.IgnorePatterns(root))
+		if err != nil {
+			return nil, nil, err
+		}
+		idx := r.Index(root)
+		idx.Prune(paths)
+
+		var fromRoot []string
+		for _, p := range paths {
+			s := idx.Script(p)
+			rel := s.PathWithoutRoot()
+			if owner := shadowingRoot(rel, owners, executables); owner != "" {
+				shadowed = append(shadowed, ShadowedScript{Script: s, ShadowedBy: owner})
+				continue
+			}
+			scripts = append(scripts, s)
+			fromRoot = append(fromRoot, rel)
+		}
+		// Register after the walk so a root never shadows itself
+		for _, rel := range fromRoot {
+			executables[rel] = true
+			for _, prefix := range pathPrefixes(rel) {
+				if _, ok := owners[prefix]; !ok {
+					owners[prefix] = root
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(scripts, func(i, j int) bool {
+		return scripts[i].PathWithoutRoot() < scripts[j].PathWithoutRoot()
+	})
+	return scripts, shadowed, nil
+}
+
+// shadowingRoot returns the earlier root hiding rel: either the same path,
+// or an executable at one of its parent paths which would consume the rest as args
+func shadowingRoot(rel string, owners map[string]string, executables map[string]bool) string {
+	if owner, ok := owners[rel]; ok {
+		return owner
+	}
+	prefixes := pathPrefixes(rel)
+	for _, prefix := range prefixes[:len(prefixes)-1] {
+		if executables[prefix] {
+			return owners[prefix]
+		}
+	}
+	return ""
+}
+
+// pathPrefixes returns a/b/c as [a a/b a/b/c]
+func pathPrefixes(rel string) []string {
+	segments := strings.Split(rel, string(filepath.Separator))
+	prefixes := make([]string, len(segments))
+	for i := range segments {
+		prefixes[i] = strings.Join(segments[:i+1], string(filepath.Separator))
+	}
+	return prefixes
+}
+
+// RootFor returns the configured root containing p, falling back to the primary root
+func (r *ScriptRoots) RootFor(p string) string {
+	for _, root := range r.dirs {
+		if p == root || strings.HasPrefix(p, root+string(filepath.Separator)) {
+			return root
+		}
+	}
+	return r.config.RootDir()
+}
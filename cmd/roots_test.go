@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// setupTestRoots creates one temp directory per root and configures them in order
+func setupTestRoots(t *testing.T, count int) []string {
+	t.Helper()
+	setupTestIndex(t)
+	var roots []string
+	for i := 0; i < count; i++ {
+		roots = append(roots, t.TempDir())
+	}
+	setupTestConfig(t, strings.Join(roots, string(filepath.ListSeparator)), "tome-cli")
+	return roots
+}
+
+func writeTestScript(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConfigRootDirs tests parsing of the ordered root list
+func TestConfigRootDirs(t *testing.T) {
+	t.Run("splits colon separated roots", func(t *testing.T) {
+		config := setupTestConfig(t, "/a:/b::/a", "tome-cli")
+		if got := config.RootDirs(); !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+			t.Errorf("expected [/a /b], got %v", got)
+		}
+		if config.RootDir() != "/a" {
+			t.Errorf("expected primary root /a, got %s", config.RootDir())
+		}
+	})
+
+	t.Run("accepts repeated flag values", func(t *testing.T) {
+		config := setupTestConfig(t, "", "tome-cli")
+		viper.Set("root", []string{"/a", "/b:/c"})
+		if got := config.RootDirs(); !reflect.DeepEqual(got, []string{"/a", "/b", "/c"}) {
+			t.Errorf("expected [/a /b /c], got %v", got)
+		}
+	})
+
+	t.Run("executable specific env var wins", func(t *testing.T) {
+		config := setupTestConfig(t, "/a", "tome-cli")
+		t.Setenv("TOME_CLI_ROOT", "/x:/y")
+		if got := config.RootDirs(); !reflect.DeepEqual(got, []string{"/x", "/y"}) {
+			t.Errorf("expected [/x /y], got %v", got)
+		}
+	})
+}
+
+// TestScriptRoots tests the merged command tree across roots
+func TestScriptRoots(t *testing.T) {
+	t.Run("earlier roots shadow later roots", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "deploy"), "#!/bin/bash\n# USAGE: $0 <personal>\n")
+		writeTestScript(t, filepath.Join(roots[1], "deploy"), "#!/bin/bash\n# USAGE: $0 <team>\n")
+		writeTestScript(t, filepath.Join(roots[1], "db", "restore"), "#!/bin/bash\n")
+
+		sr := NewScriptRoots(NewConfig())
+		scripts, shadowed, err := sr.Collect()
+		if err != nil {
+			t.Fatalf("Collect() returned error: %v", err)
+		}
+
+		var names []string
+		for _, s := range scripts {
+			names = append(names, strings.Join(s.PathSegments(), " ")+"@"+s.root)
+		}
+		expected := []string{"db restore@" + roots[1], "deploy@" + roots[0]}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+
+		if len(shadowed) != 1 {
+			t.Fatalf("expected 1 shadowed script, got %d", len(shadowed))
+		}
+		if shadowed[0].Script.root != roots[1] || shadowed[0].ShadowedBy != roots[0] {
+			t.Errorf("unexpected shadowed script: %+v", shadowed[0])
+		}
+	})
+
+	t.Run("executable in earlier root shadows nested scripts", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "aws"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", "login"), "#!/bin/bash\n")
+
+		sr := NewScriptRoots(NewConfig())
+		_, shadowed, err := sr.Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shadowed) != 1 || shadowed[0].Script.PathWithoutRoot() != filepath.Join("aws", "login") {
+			t.Errorf("expected aws/login to be shadowed, got %+v", shadowed)
+		}
+	})
+
+	t.Run("resolve searches roots in order", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "aws", "login"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", "deploy"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", "login"), "#!/bin/bash\n")
+
+		sr := NewScriptRoots(NewConfig())
+
+		s, rest := sr.Resolve([]string{"aws", "deploy", "prod"})
+		if s == nil || s.path != filepath.Join(roots[1], "aws", "deploy") {
+			t.Fatalf("expected aws/deploy from second root, got %+v", s)
+		}
+		if !reflect.DeepEqual(rest, []string{"prod"}) {
+			t.Errorf("expected remaining args [prod], got %v", rest)
+		}
+
+		s, _ = sr.Resolve([]string{"aws", "login"})
+		if s == nil || s.root != roots[0] {
+			t.Errorf("expected aws/login from first root, got %+v", s)
+		}
+
+		if s, _ := sr.Resolve([]string{"missing"}); s != nil {
+			t.Errorf("expected no script, got %+v", s)
+		}
+	})
+
+	t.Run("read dir merges entries", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "aws", "login"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", "deploy"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "aws", "login"), "#!/bin/bash\n")
+
+		sr := NewScriptRoots(NewConfig())
+		entries := sr.ReadDir([]string{"aws"})
+		expected := []DirEntry{
+			{Name: "deploy", Root: roots[1]},
+			{Name: "login", Root: roots[0]},
+		}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf("expected %v, got %v", expected, entries)
+		}
+	})
+
+	t.Run("hooks are merged across roots", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-auth"), "#!/bin/bash\necho personal\n")
+		writeTestScript(t, filepath.Join(roots[1], ".hooks.d", "00-auth"), "#!/bin/bash\necho team\n")
+		writeTestScript(t, filepath.Join(roots[1], ".hooks.d", "10-check"), "#!/bin/bash\n")
+
+		hooks, err := NewHookRunner(NewConfig()).DiscoverHooks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hooks) != 2 {
+			t.Fatalf("expected 2 hooks, got %d", len(hooks))
+		}
+		if hooks[0].Path != filepath.Join(roots[0], ".hooks.d", "00-auth") {
+			t.Errorf("expected 00-auth from first root, got %s", hooks[0].Path)
+		}
+		if hooks[1].Name != "10-check" {
+			t.Errorf("expected 10-check second, got %s", hooks[1].Name)
+		}
+	})
+}
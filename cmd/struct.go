@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -23,14 +24,24 @@ type Script struct {
 	usage string
 	help  string
 	root  string
+	// hasCompletions is populated from the script index to avoid re-reading the file
+	hasCompletions *bool
+	metadata       *ScriptMetadata
 }
 
+// completionMarker matches TOME_COMPLETION on its own or as TOME_COMPLETION=v2,
+// but not markers sharing its prefix such as TOME_COMPLETION_CACHE=
+var completionMarker = regexp.MustCompile(`\bTOME_COMPLETION\b`)
+
 func (s *Script) HasCompletions() bool {
+	if s.hasCompletions != nil {
+		return *s.hasCompletions
+	}
 	body, err := os.ReadFile(s.path)
 	if err != nil {
 		return false
 	}
-	return strings.Contains(string(body), "TOME_COMPLETION")
+	return completionMarker.Match(body)
 }
 
 func (s *Script) IsDir() bool {
@@ -103,7 +114,7 @@ func (s *Script) ParseV2() (string, string, error) {
 				regexes := []regexp.Regexp{
 					*regexp.MustCompile(`(USAGE|SUMMARY):`),
 					*regexp.MustCompile(fmt.Sprintf(`(%s|%s)`, regexp.QuoteMeta(`$0`), regexp.QuoteMeta(filepath.Base(s.path)))),
-					*regexp.MustCompile(`TOME_[A-Z_]+`), // ignore tome option flags
+					*regexp.MustCompile(`TOME_[A-Z_]+(=\S+)?`), // ignore tome option flags
 				}
 				for _, r := range regexes {
 					withoutCommentChars = r.ReplaceAllLiteralString(withoutCommentChars, "")
@@ -152,6 +163,14 @@ func (s *Script) Help() string {
 	return dedent.Dedent(s.help)
 }
 
+// Metadata returns the structured header sections of the script
+func (s *Script) Metadata() *ScriptMetadata {
+	if s.metadata == nil {
+		s.metadata = ParseMetadata(s.help)
+	}
+	return s.metadata
+}
+
 func (s *Script) PathWithoutRoot() string {
 	return strings.TrimPrefix(strings.TrimPrefix(s.path, s.root), string(filepath.Separator))
 }
@@ -160,16 +179,64 @@ func (s *Script) PathSegments() []string {
 	return strings.Split(s.PathWithoutRoot(), string(filepath.Separator))
 }
 
-func (s *Script) PrintUsage() {
-	fmt.Printf("%s: %s\n", strings.Join(s.PathSegments(), " "), s.Usage())
+// WriteUsage writes the script's command path and usage to w
+func (s *Script) WriteUsage(w io.Writer) {
+	fmt.Fprintf(w, "%s: %s\n", strings.Join(s.PathSegments(), " "), s.Usage())
+}
+
+// WriteUsageWithRoot writes the usage followed by the root providing the script,
+// used when multiple roots are merged into one tree
+func (s *Script) WriteUsageWithRoot(w io.Writer) {
+	fmt.Fprintf(w, "%s: %s [%s]\n", strings.Join(s.PathSegments(), " "), s.Usage(), s.root)
 }
 
 // PrintHelp prints the full help text for the script
-// Help is inclusive of Usage and does not strip out
-// the script name or $0
-// TODO: consider stripping out leading comment characters such as #, //, etc
+// Headers using sections such as DESCRIPTION: or ARGUMENTS: are rendered
+// with headings, free-form headers are printed as written which is inclusive
+// of Usage and does not strip out the script name or $0
 func (s *Script) PrintHelp() {
-	fmt.Printf("%s\n---\n%s\n", strings.Join(s.PathSegments(), " "), s.Help())
+	s.WriteHelp(os.Stdout)
+}
+
+// WriteHelp writes the help printed by PrintHelp to w
+func (s *Script) WriteHelp(w io.Writer) {
+	command := strings.Join(s.PathSegments(), " ")
+	metadata := s.Metadata()
+	if !metadata.sectioned {
+		fmt.Fprintf(w, "%s\n---\n%s\n", command, s.Help())
+		return
+	}
+	fmt.Fprintf(w, "%s\n---\n", command)
+	metadata.Render(w, command, s.Usage())
+}
+
+// ScriptInfo is the machine readable description of a script
+// emitted by help --output json|yaml
+type ScriptInfo struct {
+	Command        []string        `json:"command" yaml:"command"`
+	Path           string          `json:"path" yaml:"path"`
+	Usage          string          `json:"usage" yaml:"usage"`
+	Help           string          `json:"help" yaml:"help"`
+	HasCompletions bool            `json:"has_completions" yaml:"has_completions"`
+	Executable     bool            `json:"executable" yaml:"executable"`
+	Root           string          `json:"root" yaml:"root"`
+	Metadata       *ScriptMetadata `json:"metadata" yaml:"metadata"`
+}
+
+func (s *Script) Info() ScriptInfo {
+	info := ScriptInfo{
+		Command:  s.PathSegments(),
+		Path:     s.path,
+		Usage:    s.Usage(),
+		Help:     s.Help(),
+		Root:     s.root,
+		Metadata: s.Metadata(),
+	}
+	if fileInfo, err := os.Stat(s.path); err == nil && !fileInfo.IsDir() {
+		info.Executable = isExecutableByOwner(fileInfo.Mode())
+		info.HasCompletions = info.Executable && s.HasCompletions()
+	}
+	return info
 }
 
 func NewScript(path string, root string) *Script {
@@ -186,8 +253,14 @@ func NewConfig() *Config {
 }
 
 func (c *Config) IgnorePatterns() *gitignore.GitIgnore {
+	return c.IgnorePatternsFor(c.RootDir())
+}
+
+// IgnorePatternsFor compiles the .tomeignore file of a single root
+// along with the ignore patterns of the config files
+func (c *Config) IgnorePatternsFor(root string) *gitignore.GitIgnore {
 	tomeIgnore := ".tomeignore"
-	tomeIgnorePath := filepath.Join(c.RootDir(), tomeIgnore)
+	tomeIgnorePath := filepath.Join(root, tomeIgnore)
 	_, err := os.Stat(tomeIgnorePath)
 	if err == nil {
 		var txt []byte
@@ -196,9 +269,9 @@ func (c *Config) IgnorePatterns() *gitignore.GitIgnore {
 			fmt.Printf(`Failed to read tome ignore file`)
 			os.Exit(1)
 		}
-		return gitignore.CompileIgnoreLines(strings.Split(string(txt), "\n")...)
+		return gitignore.CompileIgnoreLines(append(strings.Split(string(txt), "\n"), c.IgnoreGlobs()...)...)
 	}
-	return gitignore.CompileIgnoreLines()
+	return gitignore.CompileIgnoreLines(c.IgnoreGlobs()...)
 }
 
 func (c *Config) EnvVarWithSuffix(suffix string) (string, bool) {
@@ -209,7 +282,12 @@ func (c *Config) EnvVarWithSuffix(suffix string) (string, bool) {
 	return val, ok
 }
 
+// EnvVarOrViperValue returns a flag given on the command line, then the executable
+// specific variable and then whatever viper resolves from TOME_ variables, config files and defaults
 func (c *Config) EnvVarOrViperValue(val string) string {
+	if c.flagChanged(val) {
+		return viper.GetViper().GetString(val)
+	}
 	v, ok := c.EnvVarWithSuffix(val)
 	if ok {
 		return v
@@ -217,10 +295,73 @@ func (c *Config) EnvVarOrViperValue(val string) string {
 	return viper.GetViper().GetString(val)
 }
 
+// RootDir returns the primary root, which takes precedence over all others
 func (c *Config) RootDir() string {
-	return c.EnvVarOrViperValue("root")
+	return c.RootDirs()[0]
+}
+
+// RootDirs returns the ordered list of script roots.
+// Roots come from repeated --root flags, a colon separated
+// TOME_ROOT or the root of a config file, and earlier roots shadow later ones.
+// The root of the project containing the working directory comes after them,
+// so it adds the project's scripts without shadowing configured ones.
+func (c *Config) RootDirs() []string {
+	var raw []string
+	if v, ok := c.EnvVarWithSuffix("root"); ok && !c.flagChanged("root") {
+		raw = append(raw, v)
+	} else if viper.GetViper().IsSet("root") {
+		switch v := viper.GetViper().Get("root").(type) {
+		case string:
+			raw = append(raw, v)
+		case []string:
+			raw = append(raw, v...)
+		case []interface{}:
+			for _, item := range v {
+				raw = append(raw, fmt.Sprint(item))
+			}
+		}
+	}
+	if project := c.ProjectRoot(); project != nil {
+		raw = append(raw, project.Dir)
+	}
+
+	if len(raw) == 0 {
+		raw = []string{"."}
+	}
+
+	var roots []string
+	seen := map[string]bool{}
+	for _, r := range raw {
+		for _, root := range filepath.SplitList(r) {
+			if root == "" {
+				continue
+			}
+			if abs, err := filepath.Abs(root); err == nil {
+				root = abs
+			}
+			if seen[root] {
+				continue
+			}
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	return roots
 }
 
 func (c *Config) ExecutableName() string {
 	return c.EnvVarOrViperValue("executable")
 }
+
+// IndexEnabled reports whether the on-disk script index should be used
+func (c *Config) IndexEnabled() bool {
+	return c.EnvVarOrViperValue("index") != "false"
+}
+
+// ScriptIndex loads the script index for the root directory
+func (c *Config) ScriptIndex() *ScriptIndex {
+	return LoadScriptIndex(c.RootDir(), c.IndexEnabled())
+}
@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SuperviseMarker in a script's header always runs it as a supervised child
+const SuperviseMarker = "TOME_SUPERVISE"
+
+// ExecResult describes a script which ran as a supervised child of tome-cli
+type ExecResult struct {
+	ExitCode  int
+	Signal    syscall.Signal
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// Failed reports whether the script exited non-zero or was killed by a signal
+func (r *ExecResult) Failed() bool {
+	return r.ExitCode != 0
+}
+
+// forwardedSignals are relayed from tome-cli to the supervised child
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// execObservers are told about every run of a script, e.g. to record history
+// or send notifications. Supervised runs are reported once they finished,
+// other runs just before the script replaces tome-cli with a nil result.
+var execObservers []func(script *Script, args []string, result *ExecResult)
+
+// ObserveExec registers observer to be called for each run of a script
+// with the arguments given to the script on the command line
+func ObserveExec(observer func(script *Script, args []string, result *ExecResult)) {
+	execObservers = append(execObservers, observer)
+}
+
+// notifyExecObservers reports a finished run to every observer
+func notifyExecObservers(script *Script, args []string, result *ExecResult) {
+	for _, observer := range execObservers {
+		observer(script, args, result)
+	}
+}
+
+// Supervised reports whether the script opted into always running supervised
+func (s *Script) Supervised() bool {
+	return strings.Contains(s.help, SuperviseMarker)
+}
+
+// Supervise reports whether scripts run as supervised children, by --supervise or supervise
+func (c *Config) Supervise() bool {
+	supervise, _ := strconv.ParseBool(c.EnvVarOrViperValue("supervise"))
+	return supervise
+}
+
+// supervise runs argv as a child process instead of replacing tome-cli,
+// forwarding signals and reporting how it exited.
+// A child killed by a signal reports the shell convention of 128+signal.
+func supervise(arv0 string, argv []string, env []string) (*ExecResult, error) {
+	cmd := &exec.Cmd{
+		Path:   arv0,
+		Args:   argv,
+		Env:    mergeEnv(os.Environ(), env),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	result := &ExecResult{StartedAt: time.Now()}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	interactive := isTerminal(os.Stdin)
+	go func() {
+		for sig := range signals {
+			// The terminal already delivers keyboard and resize signals to the
+			// whole foreground process group, forwarding them would deliver twice
+			if interactive && (sig == syscall.SIGINT || sig == syscall.SIGQUIT || sig == syscall.SIGWINCH) {
+				continue
+			}
+			log.Debugw("forwarding signal", "signal", sig, "pid", cmd.Process.Pid)
+			if err := cmd.Process.Signal(sig); err != nil {
+				log.Debugw("unable to forward signal", "signal", sig, "error", err)
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	result.Duration = time.Since(result.StartedAt)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal()
+		result.ExitCode = 128 + int(status.Signal())
+	} else {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	log.Debugw("supervised script finished", "exit_code", result.ExitCode, "duration", result.Duration)
+	return result, nil
+}
+
+// superviseOrLog runs argv under supervision, calling after once it finished,
+// and returns the exit code tome-cli should exit with.
+// A script which can't be started is reported to after as having failed with exit code 1.
+func superviseOrLog(arv0 string, argv []string, env []string, after func(*ExecResult)) int {
+	if dryRun {
+		fmt.Printf("dry run (supervised):\nbinary: %s\nargs: %+v\nenv (injected):\n%+v\n", arv0, strings.Join(argv, " "), strings.Join(env, "\n"))
+		return 0
+	}
+
+	result, err := supervise(arv0, argv, env)
+	if err != nil {
+		fmt.Printf("Error executing command: %v\n", err)
+		after(&ExecResult{ExitCode: 1, StartedAt: time.Now()})
+		return 1
+	}
+	after(result)
+	return result.ExitCode
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device as well, but nobody answers on it
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSupervise tests running a script as a supervised child
+func TestSupervise(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+
+	t.Run("preserves exit status", func(t *testing.T) {
+		result, err := supervise("/bin/sh", []string{"sh", "-c", "exit 7"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitCode != 7 || !result.Failed() {
+			t.Errorf("expected exit code 7, got %+v", result)
+		}
+	})
+
+	t.Run("passes injected env", func(t *testing.T) {
+		result, err := supervise("/bin/sh", []string{"sh", "-c", `[ "$TOME_TEST" = "yes" ]`}, []string{"TOME_TEST=yes"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitCode != 0 {
+			t.Errorf("expected injected env to reach the child, got exit %d", result.ExitCode)
+		}
+	})
+
+	t.Run("killed child exits 128 plus signal", func(t *testing.T) {
+		result, err := supervise("/bin/sh", []string{"sh", "-c", "kill -TERM $$"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Signal != syscall.SIGTERM || result.ExitCode != 128+int(syscall.SIGTERM) {
+			t.Errorf("expected SIGTERM and exit 143, got %+v", result)
+		}
+	})
+
+	t.Run("missing binary is an error", func(t *testing.T) {
+		if _, err := supervise("/nonexistent/binary", []string{"binary"}, nil); err == nil {
+			t.Error("expected an error for a missing binary")
+		}
+	})
+}
+
+// TestAfterHooks tests post and on-failure hooks
+func TestAfterHooks(t *testing.T) {
+	roots := setupTestRoots(t, 2)
+	outputFile := filepath.Join(t.TempDir(), "after.txt")
+	report := "#!/bin/bash\necho \"$TOME_HOOK_PHASE $(basename \"$0\") $TOME_SCRIPT_EXIT_CODE $TOME_SCRIPT_NAME\" >> " + outputFile + "\n"
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "post.d", "10-report"), report)
+	writeTestScript(t, filepath.Join(roots[1], ".hooks.d", "post.d", "00-first"), report+"exit 1\n")
+	writeTestScript(t, filepath.Join(roots[1], ".hooks.d", "on-failure.d", "00-alert"), report)
+	writeTestScript(t, filepath.Join(roots[0], ".hooks.d", "00-pre"), "#!/bin/bash\n")
+
+	hr := NewHookRunner(NewConfig())
+
+	pre, err := hr.DiscoverHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pre) != 1 || pre[0].Name != "00-pre" {
+		t.Errorf("expected phase directories to be ignored by pre hooks, got %+v", pre)
+	}
+
+	post, err := hr.DiscoverPhaseHooks(HookPhasePost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(post) != 2 || post[0].Name != "00-first" || post[1].Name != "10-report" {
+		t.Fatalf("expected post hooks merged across roots in order, got %+v", post)
+	}
+
+	result := &ExecResult{ExitCode: 2, Duration: 1500 * time.Millisecond}
+	env := hr.afterHookEnv(HookPhasePost, "/path/to/deploy", []string{"prod"}, result)
+	for _, expected := range []string{"TOME_SCRIPT_EXIT_CODE=2", "TOME_SCRIPT_DURATION_MS=1500", "TOME_HOOK_PHASE=post"} {
+		if !slices.Contains(env, expected) {
+			t.Errorf("expected %s in hooks env", expected)
+		}
+	}
+
+	// A failing hook must not stop later hooks
+	hr.RunAfterHooks(HookPhasePost, post, "/path/to/deploy", []string{"prod"}, result, nil)
+	lines, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "post 00-first 2 deploy\npost 10-report 2 deploy\n"
+	if string(lines) != expected {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+// TestSuperviseSignals tests forwarding signals to a supervised child
+func TestSuperviseSignals(t *testing.T) {
+	setupTestConfig(t, t.TempDir(), "tome-cli")
+	// Without a terminal tome-cli is the only one to relay resize signals
+	stdin, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	previous := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = previous })
+
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	script := "trap 'exit 3' WINCH; touch " + ready + "; while :; do sleep 0.01; done"
+	results := make(chan *ExecResult, 1)
+	go func() {
+		result, err := supervise("/bin/sh", []string{"sh", "-c", script}, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	for i := 0; i < 500; i++ {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-results:
+		if result == nil || result.ExitCode != 3 {
+			t.Errorf("expected the child to trap SIGWINCH and exit 3, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGWINCH to be forwarded")
+	}
+}
+
+// TestSupervisedExec tests opting a script into supervision and observing its run
+func TestSupervisedExec(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	writeTestScript(t, filepath.Join(roots[0], "greet"), "#!/bin/sh\n# USAGE: $0 <name>\n# TOME_SUPERVISE\nexit 0\n")
+
+	var observed []string
+	previous := execObservers
+	t.Cleanup(func() { execObservers = previous })
+	ObserveExec(func(script *Script, args []string, result *ExecResult) {
+		observed = append(observed, script.PathWithoutRoot())
+		observed = append(observed, args...)
+		if result.ExitCode != 0 || result.Duration <= 0 {
+			t.Errorf("expected a timed successful run, got %+v", result)
+		}
+	})
+
+	if err := ExecRunE(execCmd, []string{"greet", "world"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(observed, []string{"greet", "world"}) {
+		t.Errorf("expected the supervised run to be observed, got %v", observed)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// CommandTree is a directory of the merged command tree along with
+// the scripts and subdirectories beneath it
+type CommandTree struct {
+	Name     string
+	Summary  string
+	Script   *Script
+	Children []*CommandTree
+}
+
+// directorySummaryFiles are checked in order for a namespace's own summary
+var directorySummaryFiles = []string{".description", "README", "README.md"}
+
+// Tree builds the command tree beneath the directory named by segments,
+// honoring .tomeignore and dropping directories without any scripts.
+// Symlinked directories are not followed.
+func (r *ScriptRoots) Tree(segments []string) *CommandTree {
+	name := ""
+	if len(segments) > 0 {
+		name = segments[len(segments)-1]
+	}
+	tree := &CommandTree{Name: name, Summary: r.DirectorySummary(segments)}
+
+	for _, entry := range r.ReadDir(segments) {
+		childSegments := append(append([]string{}, segments...), entry.Name)
+		fullPath := path.Join(append([]string{entry.Root}, childSegments...)...)
+		if r.IgnorePatterns(entry.Root).MatchesPath(fullPath) {
+			continue
+		}
+		s, info, err := r.Index(entry.Root).Lookup(fullPath)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			// Symlinked directories are skipped like filepath.Walk does for help, they may loop
+			if link, err := os.Lstat(fullPath); err != nil || link.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			child := r.Tree(childSegments)
+			if len(child.Children) > 0 {
+				tree.Children = append(tree.Children, child)
+			}
+		} else if s != nil {
+			tree.Children = append(tree.Children, &CommandTree{Name: entry.Name, Script: s})
+		}
+	}
+	return tree
+}
+
+// DirectorySummary returns the first line of the namespace's .description
+// or README file from the first root providing one
+func (r *ScriptRoots) DirectorySummary(segments []string) string {
+	for _, root := range r.dirs {
+		dir := path.Join(append([]string{root}, segments...)...)
+		for _, name := range directorySummaryFiles {
+			if summary := readSummaryLine(path.Join(dir, name)); summary != "" {
+				return summary
+			}
+		}
+	}
+	return ""
+}
+
+// readSummaryLine returns the first non-blank line of a file,
+// stripped of markdown heading characters
+func readSummaryLine(p string) string {
+	file, err := os.Open(p)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimLeft(scanner.Text(), "# "))
+		if line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// Render prints the tree beneath its root with usage and namespace summaries
+func (t *CommandTree) Render(w io.Writer, showRoot bool) {
+	t.renderChildren(w, "", showRoot)
+}
+
+func (t *CommandTree) renderChildren(w io.Writer, prefix string, showRoot bool) {
+	for i, child := range t.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(t.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		if child.Script == nil {
+			line := child.Name + "/"
+			if child.Summary != "" {
+				line += ": " + child.Summary
+			}
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, line)
+			child.renderChildren(w, prefix+indent, showRoot)
+			continue
+		}
+
+		line := fmt.Sprintf("%s: %s", child.Name, child.Script.Usage())
+		if showRoot {
+			line += fmt.Sprintf(" [%s]", child.Script.root)
+		}
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, strings.TrimRight(line, " "))
+	}
+}
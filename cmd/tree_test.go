@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDirectoryHelp tests rendering the command tree beneath a directory
+func TestDirectoryHelp(t *testing.T) {
+	t.Run("renders nested tree with summaries", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		root := roots[0]
+		writeTestScript(t, filepath.Join(root, "aws", "login"), "#!/bin/bash\n# USAGE: $0 <profile>\n")
+		writeTestScript(t, filepath.Join(root, "aws", "ec2", "list"), "#!/bin/bash\n# USAGE: $0 [filter]\n")
+		writeTestScript(t, filepath.Join(root, "aws", "ignored-script"), "#!/bin/bash\n")
+		if err := os.WriteFile(filepath.Join(root, ".tomeignore"), []byte("aws/ignored-script\n.*\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "aws", "README.md"), []byte("# AWS helpers\n\nLonger text\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "aws", "ec2", ".description"), []byte("EC2 instances\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Directories without scripts are omitted
+		if err := os.MkdirAll(filepath.Join(root, "aws", "empty"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		printDirectoryHelp(&buf, NewScriptRoots(NewConfig()), []string{"aws"})
+
+		expected := `aws
+---
+AWS helpers
+
+aws
+├── ec2/: EC2 instances
+│   └── list: [filter]
+└── login: <profile>
+`
+		if buf.String() != expected {
+			t.Errorf("unexpected directory help:\n%s\nexpected:\n%s", buf.String(), expected)
+		}
+	})
+
+	t.Run("merges directories across roots", func(t *testing.T) {
+		roots := setupTestRoots(t, 2)
+		writeTestScript(t, filepath.Join(roots[0], "db", "backup"), "#!/bin/bash\n")
+		writeTestScript(t, filepath.Join(roots[1], "db", "restore"), "#!/bin/bash\n")
+
+		tree := NewScriptRoots(NewConfig()).Tree([]string{"db"})
+		if len(tree.Children) != 2 {
+			t.Fatalf("expected 2 children, got %d", len(tree.Children))
+		}
+		if tree.Children[0].Script.root != roots[0] || tree.Children[1].Script.root != roots[1] {
+			t.Errorf("unexpected roots for children: %s %s", tree.Children[0].Script.root, tree.Children[1].Script.root)
+		}
+	})
+	t.Run("does not follow symlinked directories", func(t *testing.T) {
+		roots := setupTestRoots(t, 1)
+		writeTestScript(t, filepath.Join(roots[0], "ns", "deploy"), "#!/bin/bash\n")
+		if err := os.Symlink("..", filepath.Join(roots[0], "ns", "up")); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		printDirectoryHelp(&buf, NewScriptRoots(NewConfig()), []string{"ns"})
+		if expected := "ns\n---\nns\n└── deploy:\n"; buf.String() != expected {
+			t.Errorf("expected %q, got %q", expected, buf.String())
+		}
+	})
+}
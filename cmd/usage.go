@@ -0,0 +1,326 @@
+/*
+Copyright © 2024 Zander Hill <zander@xargs.io>
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateArgsMarker opts a script into argument validation against its USAGE line
+const ValidateArgsMarker = "TOME_VALIDATE_ARGS"
+
+// UsageGrammar is the parsed form of a USAGE line such as
+//
+//	<environment> {start|stop} [--force] [--region <name>] [services...]
+//
+// <args> are required, [args] optional, a trailing ... makes an argument
+// variadic and {a|b} restricts an argument to a set of choices.
+// Bare words are optional, they are as often prose as literal arguments.
+// Flags are always optional; [options] or [flags] allows undeclared flags.
+type UsageGrammar struct {
+	Positionals []UsageParam
+	Flags       []UsageFlag
+	AnyFlags    bool
+
+	// lastTokenWasFlag lets a placeholder after a flag become its value
+	lastTokenWasFlag bool
+}
+
+// UsageParam is a positional argument of the usage grammar
+type UsageParam struct {
+	Name     string
+	Required bool
+	Variadic bool
+	Choices  []string
+}
+
+// UsageFlag is a flag of the usage grammar, Value is set when the flag takes one
+type UsageFlag struct {
+	Names []string
+	Value string
+}
+
+// UsageError describes an invocation rejected by the usage grammar
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+// ParseUsage parses a USAGE line into its grammar
+func ParseUsage(usage string) *UsageGrammar {
+	g := &UsageGrammar{}
+	g.parse(usage, true)
+	return g
+}
+
+func (g *UsageGrammar) parse(usage string, required bool) {
+	for _, token := range tokenizeUsage(usage) {
+		if token == "..." {
+			if n := len(g.Positionals); n > 0 {
+				g.Positionals[n-1].Variadic = true
+			}
+			continue
+		}
+		if strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]") {
+			inner := strings.TrimSpace(token[1 : len(token)-1])
+			if lower := strings.ToLower(inner); lower == "options" || lower == "flags" {
+				g.AnyFlags = true
+				continue
+			}
+			g.parse(inner, false)
+			// A group never lends its flag to a placeholder outside of it
+			g.lastTokenWasFlag = false
+			continue
+		}
+		if strings.HasSuffix(token, "...") {
+			g.addParam(strings.TrimSuffix(token, "..."), required, true)
+			continue
+		}
+		if strings.HasPrefix(token, "-") {
+			g.addFlag(token)
+			continue
+		}
+		if strings.HasPrefix(token, "<") && len(g.Flags) > 0 && g.lastTokenWasFlag {
+			// A placeholder directly following a flag is that flag's value
+			g.Flags[len(g.Flags)-1].Value = strings.Trim(token, "<>")
+			g.lastTokenWasFlag = false
+			continue
+		}
+		g.addParam(token, required, false)
+	}
+}
+
+func (g *UsageGrammar) addParam(token string, required bool, variadic bool) {
+	g.lastTokenWasFlag = false
+	param := UsageParam{Required: required, Variadic: variadic}
+	switch {
+	case strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}"):
+		param.Choices = strings.Split(token[1:len(token)-1], "|")
+		param.Name = strings.Join(param.Choices, "|")
+	case strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">"):
+		param.Name = token[1 : len(token)-1]
+	default:
+		param.Name = token
+		param.Required = false
+	}
+	g.Positionals = append(g.Positionals, param)
+}
+
+func (g *UsageGrammar) addFlag(token string) {
+	flag := UsageFlag{}
+	for _, name := range strings.FieldsFunc(token, func(r rune) bool { return r == '|' || r == ',' }) {
+		name, value, hasValue := strings.Cut(name, "=")
+		flag.Names = append(flag.Names, name)
+		if hasValue {
+			flag.Value = strings.Trim(value, "<>")
+		}
+	}
+	g.Flags = append(g.Flags, flag)
+	g.lastTokenWasFlag = flag.Value == ""
+}
+
+// tokenizeUsage splits a usage line on whitespace while keeping
+// bracketed groups such as [--env <name>] and {a | b} intact
+func tokenizeUsage(usage string) []string {
+	var tokens []string
+	var current strings.Builder
+	depth := 0
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range usage {
+		switch {
+		case r == '[' || r == '{' || r == '<':
+			if depth == 0 && r == '[' {
+				flush()
+			}
+			depth++
+			current.WriteRune(r)
+		case r == ']' || r == '}' || r == '>':
+			depth--
+			current.WriteRune(r)
+			if depth == 0 && r == ']' {
+				flush()
+			}
+		case (r == ' ' || r == '\t') && depth == 0:
+			flush()
+		case (r == ' ' || r == '\t') && depth > 0:
+			// Collapse whitespace inside choices so {a | b} equals {a|b}
+			if !strings.HasSuffix(current.String(), "{") && !strings.HasSuffix(current.String(), "|") {
+				current.WriteRune(' ')
+			}
+		case r == '|' && depth > 0:
+			trimmed := strings.TrimRight(current.String(), " ")
+			current.Reset()
+			current.WriteString(trimmed + "|")
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	// Split a trailing ... glued to a bracketed group, e.g. [file]...
+	var split []string
+	for _, token := range tokens {
+		if strings.HasSuffix(token, "]...") {
+			split = append(split, strings.TrimSuffix(token, "..."), "...")
+			continue
+		}
+		split = append(split, token)
+	}
+	return split
+}
+
+func (g *UsageGrammar) flag(name string) *UsageFlag {
+	for i := range g.Flags {
+		for _, n := range g.Flags[i].Names {
+			if n == name {
+				return &g.Flags[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks args against the grammar. -h and --help are always accepted
+// so scripts can still print their own help.
+func (g *UsageGrammar) Validate(args []string) error {
+	positionals, help, err := g.positionals(args)
+	if help || err != nil {
+		return err
+	}
+	return g.validatePositionals(positionals)
+}
+
+// MissingArguments returns the required positionals args leaves out, in order.
+// Nothing is missing when -h or --help is given or args don't fit the grammar's flags.
+func (g *UsageGrammar) MissingArguments(args []string) []UsageParam {
+	positionals, help, err := g.positionals(args)
+	if help || err != nil {
+		return nil
+	}
+	var required []UsageParam
+	for _, p := range g.Positionals {
+		if p.Required {
+			required = append(required, p)
+		}
+	}
+	// Optional arguments only consume input once every required argument is given
+	if len(positionals) >= len(required) {
+		return nil
+	}
+	return required[len(positionals):]
+}
+
+// positionals separates the positional arguments of args from its flags and their values,
+// help reports whether -h or --help was given
+func (g *UsageGrammar) positionals(args []string) (positionals []string, help bool, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positionals = append(positionals, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			return positionals, true, nil
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positionals = append(positionals, arg)
+			continue
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		flag := g.flag(name)
+		if flag == nil {
+			if g.AnyFlags {
+				continue
+			}
+			return positionals, false, &UsageError{Message: fmt.Sprintf("unknown flag: %s", name)}
+		}
+		if flag.Value != "" && !hasValue {
+			if i+1 >= len(args) {
+				return positionals, false, &UsageError{Message: fmt.Sprintf("flag %s requires a value <%s>", name, flag.Value)}
+			}
+			i++
+		}
+	}
+	return positionals, false, nil
+}
+
+func (g *UsageGrammar) validatePositionals(args []string) error {
+	required := 0
+	for _, p := range g.Positionals {
+		if p.Required {
+			required++
+		}
+	}
+
+	remaining := len(args)
+	idx := 0
+	for _, p := range g.Positionals {
+		if p.Required {
+			required--
+		}
+		// Optional arguments only consume input when the
+		// required arguments after them can still be satisfied
+		if !p.Required && remaining <= required {
+			continue
+		}
+		if remaining == 0 {
+			if p.Required {
+				return &UsageError{Message: fmt.Sprintf("missing required argument <%s>", p.Name)}
+			}
+			continue
+		}
+
+		take := 1
+		if p.Variadic {
+			take = remaining - required
+			if take < 1 {
+				take = 1
+			}
+		}
+		for _, value := range args[idx : idx+take] {
+			if len(p.Choices) > 0 && !containsString(p.Choices, value) {
+				return &UsageError{Message: fmt.Sprintf("invalid value %q for <%s>, expected one of: %s", value, p.Name, strings.Join(p.Choices, ", "))}
+			}
+		}
+		idx += take
+		remaining -= take
+	}
+
+	if remaining > 0 {
+		return &UsageError{Message: fmt.Sprintf("unexpected arguments: %s", strings.Join(args[idx:], " "))}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatesArgs reports whether the script opted into usage validation
+func (s *Script) ValidatesArgs() bool {
+	return strings.Contains(s.help, ValidateArgsMarker)
+}
+
+// UsageGrammar returns the parsed USAGE line of the script,
+// nil when the header starts with a SUMMARY: describing the script instead
+func (s *Script) UsageGrammar() *UsageGrammar {
+	if strings.HasPrefix(s.help, "SUMMARY:") {
+		return nil
+	}
+	return ParseUsage(s.Usage())
+}
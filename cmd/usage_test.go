@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseUsage tests parsing of USAGE lines into a grammar
+func TestParseUsage(t *testing.T) {
+	t.Run("parses positionals, choices and flags", func(t *testing.T) {
+		g := ParseUsage("<environment> {start | stop} [--force] [--region <name>] [services...]")
+
+		expectedPositionals := []UsageParam{
+			{Name: "environment", Required: true},
+			{Name: "start|stop", Required: true, Choices: []string{"start", "stop"}},
+			{Name: "services", Variadic: true},
+		}
+		if !reflect.DeepEqual(g.Positionals, expectedPositionals) {
+			t.Errorf("expected positionals %+v, got %+v", expectedPositionals, g.Positionals)
+		}
+
+		expectedFlags := []UsageFlag{
+			{Names: []string{"--force"}},
+			{Names: []string{"--region"}, Value: "name"},
+		}
+		if !reflect.DeepEqual(g.Flags, expectedFlags) {
+			t.Errorf("expected flags %+v, got %+v", expectedFlags, g.Flags)
+		}
+		if g.AnyFlags {
+			t.Error("expected undeclared flags to be rejected")
+		}
+	})
+
+	t.Run("options placeholder allows any flag", func(t *testing.T) {
+		g := ParseUsage("[options] <file>...")
+		if !g.AnyFlags {
+			t.Error("expected [options] to allow undeclared flags")
+		}
+		if len(g.Positionals) != 1 || !g.Positionals[0].Variadic || !g.Positionals[0].Required {
+			t.Errorf("expected one required variadic positional, got %+v", g.Positionals)
+		}
+	})
+
+	t.Run("bare words are optional", func(t *testing.T) {
+		g := ParseUsage("script.sh [options] <arg1> <arg2>")
+		expected := []UsageParam{
+			{Name: "script.sh"},
+			{Name: "arg1", Required: true},
+			{Name: "arg2", Required: true},
+		}
+		if !reflect.DeepEqual(g.Positionals, expected) {
+			t.Errorf("expected %+v, got %+v", expected, g.Positionals)
+		}
+		if err := g.Validate([]string{"a", "b"}); err != nil {
+			t.Errorf("expected the placeholders to be filled, got %v", err)
+		}
+	})
+
+	t.Run("trailing ellipsis after group", func(t *testing.T) {
+		g := ParseUsage("<src> [dest]...")
+		expected := []UsageParam{
+			{Name: "src", Required: true},
+			{Name: "dest", Variadic: true},
+		}
+		if !reflect.DeepEqual(g.Positionals, expected) {
+			t.Errorf("expected %+v, got %+v", expected, g.Positionals)
+		}
+	})
+}
+
+// TestUsageGrammarValidate tests validation of invocations against a grammar
+func TestUsageGrammarValidate(t *testing.T) {
+	g := ParseUsage("<environment> {start|stop} [--force] [--region <name>] [services...]")
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"valid minimal", []string{"prod", "start"}, ""},
+		{"valid with flags and variadic", []string{"prod", "stop", "--force", "--region", "us", "api", "web"}, ""},
+		{"flag value with equals", []string{"--region=us", "prod", "start"}, ""},
+		{"help bypasses validation", []string{"--help"}, ""},
+		{"double dash ends flags", []string{"prod", "start", "--", "--not-a-flag"}, ""},
+		{"missing required", []string{"prod"}, "missing required argument <start|stop>"},
+		{"invalid choice", []string{"prod", "restart"}, `invalid value "restart" for <start|stop>, expected one of: start, stop`},
+		{"unknown flag", []string{"prod", "start", "--bogus"}, "unknown flag: --bogus"},
+		{"flag missing value", []string{"prod", "start", "--region"}, "flag --region requires a value <name>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.Validate(tt.args)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("optional before required", func(t *testing.T) {
+		g := ParseUsage("[profile] <target>")
+		if err := g.Validate([]string{"prod"}); err != nil {
+			t.Errorf("expected single arg to fill <target>, got %v", err)
+		}
+		if err := g.Validate([]string{"dev", "prod"}); err != nil {
+			t.Errorf("expected two args to be accepted, got %v", err)
+		}
+		if err := g.Validate([]string{"a", "b", "c"}); err == nil || !strings.Contains(err.Error(), "unexpected arguments: c") {
+			t.Errorf("expected unexpected arguments error, got %v", err)
+		}
+	})
+}
+
+// TestScriptValidatesArgs tests the opt-in marker
+func TestScriptValidatesArgs(t *testing.T) {
+	root := t.TempDir()
+	setupTestConfig(t, root, "tome-cli")
+
+	opted := filepath.Join(root, "opted")
+	writeTestScript(t, opted, "#!/bin/bash\n# USAGE: $0 <name>\n# TOME_VALIDATE_ARGS\n")
+	plain := filepath.Join(root, "plain")
+	writeTestScript(t, plain, "#!/bin/bash\n# USAGE: $0 <name>\n")
+
+	if !NewScript(opted, root).ValidatesArgs() {
+		t.Error("expected script with TOME_VALIDATE_ARGS to validate args")
+	}
+	if NewScript(plain, root).ValidatesArgs() {
+		t.Error("expected script without marker not to validate args")
+	}
+	if got := NewScript(opted, root).UsageGrammar().Positionals; len(got) != 1 || got[0].Name != "name" {
+		t.Errorf("unexpected grammar positionals: %+v", got)
+	}
+
+	summary := filepath.Join(root, "summary")
+	writeTestScript(t, summary, "#!/bin/bash\n# SUMMARY: Restart the <web> servers\n# TOME_VALIDATE_ARGS\n")
+	if got := NewScript(summary, root).UsageGrammar(); got != nil {
+		t.Errorf("expected no grammar from a SUMMARY line, got %+v", got)
+	}
+}
+
+// TestUsageGrammarMissingArguments tests finding the required arguments left out
+func TestUsageGrammarMissingArguments(t *testing.T) {
+	g := ParseUsage("[--region <name>] [verbose] <environment> {start|stop} [services...]")
+	names := func(params []UsageParam) []string {
+		var got []string
+		for _, p := range params {
+			got = append(got, p.Name)
+		}
+		return got
+	}
+
+	cases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{"none given", nil, []string{"environment", "start|stop"}},
+		{"flag values are not positionals", []string{"--region", "eu", "prod"}, []string{"start|stop"}},
+		{"all given", []string{"prod", "start", "web"}, nil},
+		{"help", []string{"--help"}, nil},
+		{"unknown flags", []string{"--force"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := names(g.MissingArguments(tc.args)); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestValidateArgsCommandLine tests that flags of the USAGE line given after the script's path are validated
+func TestValidateArgsCommandLine(t *testing.T) {
+	roots := setupTestRoots(t, 1)
+	setupTestRun(t)
+	output := filepath.Join(t.TempDir(), "output")
+	t.Setenv("OUTPUT", output)
+	writeTestScript(t, filepath.Join(roots[0], "deploy"), `#!/bin/sh
+# USAGE: $0 <environment> {start|stop} [--force] [--region <name>]
+# TOME_VALIDATE_ARGS
+echo "$*" > "$OUTPUT"
+`)
+
+	executeRootCmd(t, "deploy", "prod", "start", "--force", "--region", "eu")
+	if got := strings.TrimSpace(string(mustReadFile(t, output))); got != "prod start --force --region eu" {
+		t.Errorf("expected the flags to reach the script, got %q", got)
+	}
+	if err := NewScript(filepath.Join(roots[0], "deploy"), roots[0]).UsageGrammar().Validate([]string{"prod", "start", "--bogus"}); err == nil {
+		t.Error("expected an undeclared flag to be rejected")
+	}
+}